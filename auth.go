@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	"zgdrive/model"
+	"zgdrive/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	sessionCookie = "zgdrive_session"
+	sessionTTL    = 7 * 24 * time.Hour
+	siweNonceTTL  = 10 * time.Minute
+)
+
+type credentialsRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type siweRequest struct {
+	Message   string `json:"message" binding:"required"`
+	Signature string `json:"signature" binding:"required"`
+}
+
+// siweConfig is what a Sign-In with Ethereum message must be bound to.
+type siweConfig struct {
+	// domains the sign-in page may be served from
+	domains []string
+	// chain the wallet must be on, any chain when 0
+	chainId int64
+}
+
+// originHosts returns the host of each origin, e.g. localhost:5173 for
+// http://localhost:5173.
+func originHosts(origins []string) []string {
+	var hosts []string
+	for _, origin := range origins {
+		u, err := url.Parse(origin)
+		if err == nil && u.Host != "" {
+			hosts = append(hosts, u.Host)
+		}
+	}
+	return hosts
+}
+
+// requireUser rejects requests without a valid session. The session token
+// is taken from an "Authorization: Bearer" header or, for the browser UI,
+// from the session cookie.
+func requireUser(ctx context.Context, dbservice services.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := sessionToken(c)
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+			return
+		}
+
+		user, err := dbservice.GetSessionUser(ctx, token)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "session is invalid or expired"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Set("user", user)
+		c.Next()
+	}
+}
+
+func sessionToken(c *gin.Context) string {
+	if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	token, _ := c.Cookie(sessionCookie)
+	return token
+}
+
+// requireAdmin rejects requests from users who are not admins. It runs
+// after requireUser.
+func requireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !currentUser(c).IsAdmin {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin only"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// currentUser returns the user requireUser signed in.
+func currentUser(c *gin.Context) model.User {
+	return c.MustGet("user").(model.User)
+}
+
+// registerAuthRoutes adds the sign in routes to router and the routes that
+// need a session to api.
+func registerAuthRoutes(router gin.IRouter, api gin.IRouter, ctx context.Context, dbservice services.Store, siwe siweConfig) {
+	// @Summary Create an account
+	// @Description Create an account. The first account can always be created and takes over files uploaded before accounts existed; more accounts need ALLOW_REGISTRATION=true.
+	// @Accept json
+	// @Produce json
+	// @Param account body credentialsRequest true "Username and password"
+	// @Success 201 {object} model.User
+	// @Failure 400 {object} gin.H "Invalid username or password too short"
+	// @Failure 403 {object} gin.H "Registration is closed"
+	// @Failure 409 {object} gin.H "Username is already taken"
+	// @Router /auth/register [post]
+	router.POST("/auth/register", func(c *gin.Context) {
+		var req credentialsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		open, err := registrationOpen(ctx, dbservice)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !open {
+			c.JSON(http.StatusForbidden, gin.H{"error": "registration is closed"})
+			return
+		}
+
+		user, err := dbservice.CreateUser(ctx, req.Username, req.Password)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, user)
+	})
+
+	// @Summary Sign in
+	// @Description Sign in with a username and password. The session token is returned and also set as a cookie; send it as "Authorization: Bearer <token>" on other requests.
+	// @Accept json
+	// @Produce json
+	// @Param account body credentialsRequest true "Username and password"
+	// @Success 200 {object} gin.H "Session token, its expiry and the user"
+	// @Failure 401 {object} gin.H "Invalid username or password"
+	// @Router /auth/login [post]
+	router.POST("/auth/login", func(c *gin.Context) {
+		var req credentialsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user, err := dbservice.Authenticate(ctx, req.Username, req.Password)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		startSession(c, ctx, dbservice, user)
+	})
+
+	// @Summary Get a Sign-In with Ethereum nonce
+	// @Description Get a single use nonce to put in an EIP-4361 message. It is valid for 10 minutes.
+	// @Produce json
+	// @Success 200 {object} gin.H "The nonce"
+	// @Router /auth/siwe/nonce [get]
+	router.GET("/auth/siwe/nonce", func(c *gin.Context) {
+		nonce, err := dbservice.CreateSiweNonce(ctx, siweNonceTTL)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, gin.H{"nonce": nonce})
+	})
+
+	// @Summary Sign in with Ethereum
+	// @Description Sign in with an EIP-4361 message signed by a wallet (personal_sign). The account is the wallet's address and is created on first sign in, under the same rules as /auth/register. The session is returned like /auth/login does.
+	// @Accept json
+	// @Produce json
+	// @Param signin body siweRequest true "Signed message and its hex encoded signature"
+	// @Success 200 {object} gin.H "Session token, its expiry and the user"
+	// @Failure 400 {object} gin.H "Malformed message"
+	// @Failure 401 {object} gin.H "Wrong domain or chain, expired message, used nonce or bad signature"
+	// @Failure 403 {object} gin.H "Registration is closed"
+	// @Router /auth/siwe/verify [post]
+	router.POST("/auth/siwe/verify", func(c *gin.Context) {
+		var req siweRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		msg, err := services.ParseSiweMessage(req.Message)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		if !slices.Contains(siwe.domains, msg.Domain) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "message is for another domain: " + msg.Domain})
+			return
+		}
+		if siwe.chainId != 0 && msg.ChainId != siwe.chainId {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("message is for chain %d, expected %d", msg.ChainId, siwe.chainId)})
+			return
+		}
+		err = msg.CheckTime(time.Now())
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		signer, err := services.RecoverSiweSigner(req.Message, req.Signature)
+		if err == nil && signer != msg.Address {
+			err = services.ErrInvalidSignature
+		}
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		// only burn the nonce for a message its wallet signed
+		err = dbservice.ConsumeSiweNonce(ctx, msg.Nonce)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		user, err := dbservice.GetWalletUser(ctx, msg.Address)
+		if errors.Is(err, sql.ErrNoRows) {
+			var open bool
+			open, err = registrationOpen(ctx, dbservice)
+			if err == nil && !open {
+				c.JSON(http.StatusForbidden, gin.H{"error": "registration is closed"})
+				return
+			}
+			if err == nil {
+				user, err = dbservice.CreateWalletUser(ctx, msg.Address)
+			}
+		}
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		startSession(c, ctx, dbservice, user)
+	})
+
+	// @Summary Sign out
+	// @Description End the current session
+	// @Success 204
+	// @Router /auth/logout [post]
+	router.POST("/auth/logout", func(c *gin.Context) {
+		if token := sessionToken(c); token != "" {
+			err := dbservice.DeleteSession(ctx, token)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		c.SetSameSite(http.SameSiteStrictMode)
+		c.SetCookie(sessionCookie, "", -1, "/", "", false, true)
+		c.Status(http.StatusNoContent)
+	})
+
+	// @Summary Current user
+	// @Description Get the signed in user
+	// @Produce json
+	// @Success 200 {object} model.User
+	// @Failure 401 {object} gin.H "Not signed in"
+	// @Router /auth/me [get]
+	api.GET("/auth/me", func(c *gin.Context) {
+		c.JSON(http.StatusOK, currentUser(c))
+	})
+}
+
+// registrationOpen reports whether a new account may be created. The first
+// one always can; more need ALLOW_REGISTRATION=true.
+func registrationOpen(ctx context.Context, dbservice services.Store) (bool, error) {
+	count, err := dbservice.CountUsers(ctx)
+	if err != nil {
+		return false, err
+	}
+	return count == 0 || os.Getenv("ALLOW_REGISTRATION") == "true", nil
+}
+
+// startSession signs user in and responds with the session token.
+func startSession(c *gin.Context, ctx context.Context, dbservice services.Store, user model.User) {
+	token, expiresAt, err := dbservice.CreateSession(ctx, user.ID, sessionTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(sessionCookie, token, int(sessionTTL.Seconds()), "/", "", c.Request.TLS != nil, true)
+	c.JSON(http.StatusOK, gin.H{"token": token, "expires_at": expiresAt, "user": user})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+	"zgdrive/model"
+	"zgdrive/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// backupMetadata uploads a snapshot of the metadata to 0G and records its
+// root hash, unless nothing changed since the last backup.
+func (w *workers) backupMetadata(ctx context.Context) error {
+	snapshot, err := w.db.Snapshot(ctx)
+	if err != nil {
+		return fmt.Errorf("taking snapshot: %w", err)
+	}
+	digest, err := services.SnapshotDigest(snapshot)
+	if err != nil {
+		return fmt.Errorf("taking snapshot: %w", err)
+	}
+	latest, err := w.db.LatestBackup(ctx)
+	if err == nil && latest.Digest == digest {
+		return nil
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("getting latest backup: %w", err)
+	}
+
+	path, err := w.layout.TempPath()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	err = writeSnapshotFile(path, snapshot, w.encryptor)
+	if err != nil {
+		return fmt.Errorf("writing snapshot: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	rootHash, err := services.FileHash(path)
+	if err != nil {
+		return fmt.Errorf("hashing snapshot: %w", err)
+	}
+
+	wallet, err := w.wallets.Acquire(ctx, 0, w.balances.Usable)
+	if err != nil {
+		return fmt.Errorf("picking a wallet for the backup: %w", err)
+	}
+	tx, err := w.storage.UploadFile(ctx, path, wallet)
+	w.wallets.Release(wallet)
+	if services.IsInsufficientFunds(err) {
+		w.balances.MarkInsufficient(wallet, err)
+	}
+	if err != nil {
+		return fmt.Errorf("uploading backup: %w", err)
+	}
+
+	// the log is written first, it is what survives losing the database
+	err = appendBackupLog(w.backupLog, rootHash, tx)
+	if err != nil {
+		fmt.Println("Error writing backup log:", err)
+	}
+	_, err = w.db.AddBackup(ctx, model.Backup{
+		RootHash:      rootHash,
+		TxId:          tx,
+		Wallet:        wallet.Hex(),
+		Size:          info.Size(),
+		Encrypted:     w.encryptor != nil,
+		SchemaVersion: snapshot.SchemaVersion,
+		Digest:        digest,
+	})
+	if err != nil {
+		return fmt.Errorf("recording backup %s: %w", rootHash, err)
+	}
+	fmt.Println("Backed up metadata, root hash:", rootHash)
+	return nil
+}
+
+func writeSnapshotFile(path string, snapshot model.Snapshot, encryptor *services.Encryptor) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = services.WriteSnapshot(f, snapshot, encryptor)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// appendBackupLog adds a backup to the log at path, one line per backup
+// with its time, root hash and transaction hash.
+func appendBackupLog(path, rootHash, tx string) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%s %s %s\n", time.Now().UTC().Format(time.RFC3339), rootHash, tx)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func registerBackupRoutes(api gin.IRouter, ctx context.Context, dbservice services.Store) {
+	admin := api.Group("/admin", requireAdmin())
+
+	// @Summary List metadata backups
+	// @Description Get the metadata snapshots uploaded to 0G, newest first. Any of them can be restored from its root hash with the restore command. Admin only.
+	// @Produce json
+	// @Success 200 {array} model.Backup
+	// @Failure 403 {object} gin.H "Not an admin"
+	// @Router /admin/backups [get]
+	admin.GET("/backups", func(c *gin.Context) {
+		backups, err := dbservice.ListBackups(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, backups)
+	})
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"zgdrive/model"
+)
+
+// client calls the zgDrive API as the signed in user.
+type client struct {
+	server string
+	token  string
+	http   *http.Client
+}
+
+func newClient(server, token string) *client {
+	return &client{
+		server: strings.TrimSuffix(server, "/"),
+		token:  token,
+		http:   &http.Client{},
+	}
+}
+
+// apiError is an error response from the API.
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	if e.Status == http.StatusUnauthorized {
+		return e.Message + ", sign in with zgdrive login or set ZGDRIVE_TOKEN"
+	}
+	return fmt.Sprintf("%s (%d)", e.Message, e.Status)
+}
+
+// do sends a request to path and returns the response when its status is
+// below 400. Otherwise the error message in the body is returned as an
+// *apiError.
+func (c *client) do(ctx context.Context, method, path string, body io.Reader, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.server+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 400 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var errBody struct {
+		Error string `json:"error"`
+	}
+	message := strings.TrimSpace(string(data))
+	if json.Unmarshal(data, &errBody) == nil && errBody.Error != "" {
+		message = errBody.Error
+	}
+	if message == "" {
+		message = http.StatusText(resp.StatusCode)
+	}
+	return nil, &apiError{Status: resp.StatusCode, Message: message}
+}
+
+// call sends in as JSON, unless it is nil, and decodes the response into
+// out, unless it is nil.
+func (c *client) call(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	contentType := ""
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+
+	resp, err := c.do(ctx, method, path, body, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// resolved is a path looked up with /resolve.
+type resolved struct {
+	Type   string        `json:"type"`
+	Folder *model.Folder `json:"folder"`
+	File   *model.File   `json:"file"`
+}
+
+func (c *client) resolve(ctx context.Context, path string) (resolved, error) {
+	var r resolved
+	err := c.call(ctx, http.MethodGet, "/resolve?path="+url.QueryEscape(path), nil, &r)
+	return r, err
+}
+
+// lookup finds the file or folder named by arg. An ID is written as
+// id:<n>. Anything else is looked up as a path first, and a bare number
+// that names nothing is taken as an ID, so files named like numbers stay
+// reachable. The returned ID is set only when arg was taken as an ID.
+func (c *client) lookup(ctx context.Context, arg string) (resolved, int64, error) {
+	if rest, ok := strings.CutPrefix(arg, "id:"); ok {
+		id, err := strconv.ParseInt(rest, 10, 64)
+		if err != nil || id < 0 {
+			return resolved{}, 0, fmt.Errorf("%s: not a valid ID", arg)
+		}
+		return resolved{}, id, nil
+	}
+
+	r, err := c.resolve(ctx, arg)
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+		id, perr := strconv.ParseInt(arg, 10, 64)
+		if perr == nil && id >= 0 {
+			return resolved{}, id, nil
+		}
+	}
+	if err != nil {
+		return resolved{}, 0, fmt.Errorf("%s: %w", arg, err)
+	}
+	return r, 0, nil
+}
+
+// fileId returns the ID of the file named by arg, an ID or a path.
+func (c *client) fileId(ctx context.Context, arg string) (int64, error) {
+	r, id, err := c.lookup(ctx, arg)
+	if err != nil || r.Type == "" {
+		return id, err
+	}
+	if r.File == nil {
+		return 0, fmt.Errorf("%s is a folder", arg)
+	}
+	return r.File.ID, nil
+}
+
+// folderId returns the ID of the folder named by arg, an ID or a path. The
+// root is id:0 or /.
+func (c *client) folderId(ctx context.Context, arg string) (int64, error) {
+	r, id, err := c.lookup(ctx, arg)
+	if err != nil || r.Type == "" {
+		return id, err
+	}
+	if r.Folder == nil {
+		return 0, fmt.Errorf("%s is a file", arg)
+	}
+	return r.Folder.ID, nil
+}
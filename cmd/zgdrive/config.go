@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// config is what login saves, so later commands reach the same server with
+// the same session.
+type config struct {
+	Server string `json:"server"`
+	Token  string `json:"token"`
+}
+
+// configPath is config.json in the user's config directory, e.g.
+// ~/.config/zgdrive on Linux.
+func configPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "zgdrive", "config.json"), nil
+}
+
+// loadConfig reads the saved config, which is empty before the first login.
+func loadConfig() (config, error) {
+	path, err := configPath()
+	if err != nil {
+		return config{}, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return config{}, nil
+	}
+	if err != nil {
+		return config{}, err
+	}
+	var cfg config
+	err = json.Unmarshal(data, &cfg)
+	if err != nil {
+		return config{}, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// saveConfig writes the config readable by the user only, since it holds
+// the session token.
+func saveConfig(cfg config) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+func sameServer(a, b string) bool {
+	return strings.TrimSuffix(a, "/") == strings.TrimSuffix(b, "/")
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+	"zgdrive/model"
+
+	"github.com/spf13/cobra"
+)
+
+// downloadStatus is the body of GET /downloadStatus/:fileId.
+type downloadStatus struct {
+	Status   bool            `json:"status"`
+	Progress *model.Progress `json:"progress"`
+}
+
+// getResult describes a file saved by get.
+type getResult struct {
+	FileId int64  `json:"file_id"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+}
+
+func newGetCommand(opts *options) *cobra.Command {
+	var output string
+	var timeout time.Duration
+	cmd := &cobra.Command{
+		Use:   "get <file>",
+		Short: "Download a file",
+		Long: `Download a file, given by ID or path. The download is queued on the
+server, and once it has been fetched from 0G the file is saved under its
+name in the current directory, or at --output. Use --output - to write
+it to standard output.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			fileId, err := c.fileId(ctx, args[0])
+			if err != nil {
+				return err
+			}
+
+			showProgress := !opts.json && isTerminal(os.Stderr)
+			err = c.waitForDownload(ctx, fileId, showProgress)
+			if err != nil {
+				return err
+			}
+			result, err := c.saveDownload(ctx, fileId, output)
+			if err != nil {
+				return err
+			}
+			if output == "-" {
+				return nil
+			}
+			if opts.json {
+				return printJSON(result)
+			}
+			fmt.Printf("Saved %s (%s)\n", result.Path, readableSize(result.Size))
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", "file or directory to save to, - for standard output")
+	cmd.Flags().DurationVar(&timeout, "timeout", 0, "give up after this long, e.g. 10m (default no limit)")
+	return cmd
+}
+
+// waitForDownload queues a download of the file and polls its status until
+// it is in the server's download cache.
+func (c *client) waitForDownload(ctx context.Context, fileId int64, showProgress bool) error {
+	id := strconv.FormatInt(fileId, 10)
+	err := c.call(ctx, http.MethodGet, "/download/"+id, nil, nil)
+	if err != nil {
+		return err
+	}
+	if showProgress {
+		defer fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+
+	for {
+		var status downloadStatus
+		err = c.call(ctx, http.MethodGet, "/downloadStatus/"+id, nil, &status)
+		if err != nil {
+			return err
+		}
+		if status.Status {
+			return nil
+		}
+		if p := status.Progress; p != nil {
+			if p.Phase == model.PhaseFailed {
+				return fmt.Errorf("downloading %s from 0G: %s", p.Filename, p.Error)
+			}
+			if showProgress {
+				fmt.Fprintf(os.Stderr, "\r\033[K%s: %s %s of %s", p.Filename, p.Phase, readableSize(p.BytesTransferred), readableSize(p.BytesTotal))
+			}
+		}
+
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// saveDownload streams a downloaded file to output. A file is written to a
+// temporary name first, so an interrupted download leaves no partial file.
+func (c *client) saveDownload(ctx context.Context, fileId int64, output string) (getResult, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/downloaded/"+strconv.FormatInt(fileId, 10), nil, "")
+	if err != nil {
+		return getResult{}, err
+	}
+	defer resp.Body.Close()
+
+	if output == "-" {
+		n, err := io.Copy(os.Stdout, resp.Body)
+		return getResult{FileId: fileId, Path: output, Size: n}, err
+	}
+
+	name := fmt.Sprintf("file-%d", fileId)
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+	if err == nil && params["filename"] != "" {
+		name = filepath.Base(params["filename"])
+	}
+	path := name
+	if output != "" {
+		path = output
+		info, err := os.Stat(output)
+		if err == nil && info.IsDir() {
+			path = filepath.Join(output, name)
+		}
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".zgdrive-")
+	if err != nil {
+		return getResult{}, err
+	}
+	defer os.Remove(f.Name())
+	n, err := io.Copy(f, resp.Body)
+	if err != nil {
+		f.Close()
+		return getResult{}, err
+	}
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		f.Close()
+		return getResult{}, errors.New("download was cut short")
+	}
+	// temporary files are only readable by their owner
+	err = f.Chmod(0o644)
+	if err != nil {
+		f.Close()
+		return getResult{}, err
+	}
+	err = f.Close()
+	if err != nil {
+		return getResult{}, err
+	}
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return getResult{}, err
+	}
+	return getResult{FileId: fileId, Path: path, Size: n}, nil
+}
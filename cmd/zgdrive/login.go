@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"zgdrive/model"
+
+	"github.com/spf13/cobra"
+)
+
+type loginResponse struct {
+	Token     string     `json:"token"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	User      model.User `json:"user"`
+}
+
+func newLoginCommand(opts *options) *cobra.Command {
+	var passwordStdin bool
+	cmd := &cobra.Command{
+		Use:   "login <username>",
+		Short: "Sign in and save the session for later commands",
+		Long: `Sign in with a username and password. The session token is saved with
+the server URL in the user's config directory and sent by later commands.
+
+The password is read from ZGDRIVE_PASSWORD, or from standard input.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			password := os.Getenv("ZGDRIVE_PASSWORD")
+			if password == "" || passwordStdin {
+				password, err = readPassword()
+				if err != nil {
+					return err
+				}
+			}
+
+			var resp loginResponse
+			err = c.call(cmd.Context(), http.MethodPost, "/auth/login", map[string]string{
+				"username": args[0],
+				"password": password,
+			}, &resp)
+			var apiErr *apiError
+			if errors.As(err, &apiErr) && apiErr.Status == http.StatusUnauthorized {
+				return errors.New(apiErr.Message)
+			}
+			if err != nil {
+				return err
+			}
+			err = saveConfig(config{Server: c.server, Token: resp.Token})
+			if err != nil {
+				return fmt.Errorf("saving session: %w", err)
+			}
+
+			if opts.json {
+				return printJSON(resp)
+			}
+			fmt.Printf("Signed in to %s as %s until %s\n", c.server, resp.User.Username, formatTime(resp.ExpiresAt))
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&passwordStdin, "password-stdin", false, "read the password from standard input even when ZGDRIVE_PASSWORD is set")
+	return cmd
+}
+
+// readPassword reads a line from standard input, prompting for it when
+// standard input is a terminal.
+func readPassword() (string, error) {
+	if isTerminal(os.Stdin) {
+		fmt.Fprint(os.Stderr, "Password: ")
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		if err != nil {
+			return "", fmt.Errorf("reading password: %w", err)
+		}
+		return "", errors.New("empty password")
+	}
+	return password, nil
+}
+
+func newLogoutCommand(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "logout",
+		Short: "End the session and forget the saved token",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			err = c.call(cmd.Context(), http.MethodPost, "/auth/logout", nil, nil)
+			if err != nil {
+				return err
+			}
+
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			if cfg.Token == c.token {
+				err = saveConfig(config{Server: cfg.Server})
+				if err != nil {
+					return err
+				}
+			}
+			if opts.json {
+				return printJSON(map[string]bool{"signed_out": true})
+			}
+			fmt.Println("Signed out of", c.server)
+			return nil
+		},
+	}
+}
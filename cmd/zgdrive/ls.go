@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"zgdrive/model"
+
+	"github.com/spf13/cobra"
+)
+
+func newLsCommand(opts *options) *cobra.Command {
+	var all bool
+	cmd := &cobra.Command{
+		Use:   "ls [folder]",
+		Short: "List a folder",
+		Long: `List the subfolders and files of a folder, given by ID or path, or of the
+root. With --all every file is listed regardless of its folder.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+
+			if all {
+				var files []model.File
+				err = c.call(ctx, http.MethodGet, "/list", nil, &files)
+				if err != nil {
+					return err
+				}
+				if opts.json {
+					return printJSON(files)
+				}
+				printListing(nil, files)
+				return nil
+			}
+
+			folder := "/"
+			if len(args) == 1 {
+				folder = args[0]
+			}
+			folderId, err := c.folderId(ctx, folder)
+			if err != nil {
+				return err
+			}
+			var contents model.FolderContents
+			err = c.call(ctx, http.MethodGet, "/folders/"+strconv.FormatInt(folderId, 10), nil, &contents)
+			if err != nil {
+				return err
+			}
+			if opts.json {
+				return printJSON(contents)
+			}
+			printListing(contents.Folders, contents.Files)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVarP(&all, "all", "a", false, "list every file")
+	return cmd
+}
+
+func printListing(folders []model.Folder, files []model.File) {
+	t := newTable()
+	fmt.Fprintln(t, "ID\tNAME\tSIZE\tSTATE\tVERSION\tCREATED")
+	for _, folder := range folders {
+		fmt.Fprintf(t, "%d\t%s/\t-\t-\t-\t%s\n", folder.ID, folder.Name, formatTime(folder.CreatedAt))
+	}
+	for _, file := range files {
+		state := "uploading"
+		if file.IsUploaded {
+			state = "stored"
+		}
+		fmt.Fprintf(t, "%d\t%s\t%s\t%s\t%d\t%s\n", file.ID, file.Filename, readableSize(file.Size), state, file.Version, formatTime(file.CreatedAt))
+	}
+	t.Flush()
+}
@@ -0,0 +1,94 @@
+// Command zgdrive is a command-line client for the zgDrive API. It signs in
+// like the browser UI does and sends the session token with every request.
+// Pass --json to get machine-readable output for scripts.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/spf13/cobra"
+)
+
+const defaultServer = "http://localhost:8080"
+
+// options are the flags shared by every command.
+type options struct {
+	server string
+	token  string
+	json   bool
+}
+
+func main() {
+	opts := &options{}
+	root := &cobra.Command{
+		Use:   "zgdrive",
+		Short: "Command-line client for zgDrive",
+		Long: `Command-line client for zgDrive.
+
+The server is taken from --server, ZGDRIVE_URL or the last login, in that
+order, and defaults to ` + defaultServer + `. The session token is taken
+from --token, ZGDRIVE_TOKEN or the last login to the same server.
+
+Files and folders are named by their path, like /docs/a.pdf, or by their
+ID, like id:42. A bare number is taken as an ID only when no file or folder
+has it as its path.`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	flags := root.PersistentFlags()
+	flags.StringVar(&opts.server, "server", "", "URL of the zgDrive API")
+	flags.StringVar(&opts.token, "token", "", "session token")
+	flags.BoolVar(&opts.json, "json", false, "print results as JSON")
+
+	root.AddCommand(
+		newLoginCommand(opts),
+		newLogoutCommand(opts),
+		newUploadCommand(opts),
+		newLsCommand(opts),
+		newGetCommand(opts),
+		newStatusCommand(opts),
+		newRmCommand(opts),
+		newShareCommand(opts),
+	)
+
+	// interrupting get or upload cancels the request in flight
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	err := root.ExecuteContext(ctx)
+	stop()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "zgdrive:", err)
+		os.Exit(1)
+	}
+}
+
+// client returns an API client for the configured server and session.
+func (o *options) client() (*client, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	server := o.server
+	if server == "" {
+		server = os.Getenv("ZGDRIVE_URL")
+	}
+	if server == "" {
+		server = cfg.Server
+	}
+	if server == "" {
+		server = defaultServer
+	}
+
+	token := o.token
+	if token == "" {
+		token = os.Getenv("ZGDRIVE_TOKEN")
+	}
+	// a saved session is only sent to the server that issued it
+	if token == "" && sameServer(cfg.Server, server) {
+		token = cfg.Token
+	}
+	return newClient(server, token), nil
+}
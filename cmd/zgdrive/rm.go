@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+// rmResult is the outcome of deleting one file.
+type rmResult struct {
+	File   string `json:"file"`
+	FileId int64  `json:"file_id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func newRmCommand(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "rm <file>...",
+		Short: "Delete files",
+		Long: `Delete files, given by ID or path, with all their versions. The content
+stays on 0G, only zgDrive forgets it.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+
+			results := make([]rmResult, 0, len(args))
+			failed := 0
+			for _, arg := range args {
+				result := rmResult{File: arg}
+				result.FileId, err = c.fileId(ctx, arg)
+				if err == nil {
+					err = c.call(ctx, http.MethodDelete, "/files/"+strconv.FormatInt(result.FileId, 10), nil, nil)
+					if err != nil {
+						err = fmt.Errorf("%s: %w", arg, err)
+					}
+				}
+				if err != nil {
+					result.Error = err.Error()
+					failed++
+				}
+				results = append(results, result)
+
+				if opts.json {
+					continue
+				}
+				if result.Error != "" {
+					fmt.Fprintln(os.Stderr, result.Error)
+				} else {
+					fmt.Println("Deleted file", result.FileId)
+				}
+			}
+
+			if opts.json {
+				err = printJSON(results)
+				if err != nil {
+					return err
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d deletions failed", failed, len(results))
+			}
+			return nil
+		},
+	}
+}
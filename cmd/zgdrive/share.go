@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"zgdrive/model"
+
+	"github.com/spf13/cobra"
+)
+
+// shareResult is a created share link with its full URL.
+type shareResult struct {
+	model.Share
+	URL string `json:"url"`
+}
+
+func newShareCommand(opts *options) *cobra.Command {
+	var permission, password string
+	var expires time.Duration
+	var maxDownloads int64
+	cmd := &cobra.Command{
+		Use:   "share <file>",
+		Short: "Create a public link to a file",
+		Long: `Create a public link to the current version of a file, given by ID or
+path. Anyone with the link can download the file, or view it in the
+browser with --permission view.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			fileId, err := c.fileId(ctx, args[0])
+			if err != nil {
+				return err
+			}
+
+			var result shareResult
+			err = c.call(ctx, http.MethodPost, "/files/"+strconv.FormatInt(fileId, 10)+"/shares", map[string]any{
+				"permission":    permission,
+				"password":      password,
+				"expires_in":    int64(expires.Seconds()),
+				"max_downloads": maxDownloads,
+			}, &result.Share)
+			if err != nil {
+				return err
+			}
+			result.URL = c.server + "/s/" + result.Token
+
+			if opts.json {
+				return printJSON(result)
+			}
+			fmt.Println(result.URL)
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&permission, "permission", model.SharePermissionDownload, "download or view")
+	flags.StringVar(&password, "password", "", "password needed to open the link")
+	flags.DurationVar(&expires, "expires", 0, "how long the link works, e.g. 24h (default forever)")
+	flags.Int64Var(&maxDownloads, "max-downloads", 0, "how many times the link can be used (default unlimited)")
+	return cmd
+}
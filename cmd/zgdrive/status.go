@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"zgdrive/model"
+
+	"github.com/spf13/cobra"
+)
+
+// statusResult is the service status and the caller's upload jobs.
+type statusResult struct {
+	Uploads string               `json:"uploads"`
+	Wallets []model.WalletStatus `json:"wallets"`
+	Jobs    []model.UploadJob    `json:"jobs"`
+}
+
+func newStatusCommand(opts *options) *cobra.Command {
+	var all bool
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show whether uploads are running and the state of your uploads",
+		Long: `Show whether the server is submitting uploads or has paused them for lack
+of funds, the balance of each paying wallet, and your upload jobs that are
+not finalized yet. With --all finalized jobs are shown too.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+
+			var status statusResult
+			err = c.call(ctx, http.MethodGet, "/status", nil, &status)
+			if err != nil {
+				return err
+			}
+			var jobs []model.UploadJob
+			err = c.call(ctx, http.MethodGet, "/uploadJobs", nil, &jobs)
+			if err != nil {
+				return err
+			}
+			status.Jobs = []model.UploadJob{}
+			for _, job := range jobs {
+				if all || job.State != model.UploadJobFinalized {
+					status.Jobs = append(status.Jobs, job)
+				}
+			}
+
+			if opts.json {
+				return printJSON(status)
+			}
+			fmt.Println("Uploads:", status.Uploads)
+			fmt.Println()
+			t := newTable()
+			fmt.Fprintln(t, "WALLET\tBALANCE\tMIN BALANCE\tSUFFICIENT\tCHECKED")
+			for _, w := range status.Wallets {
+				fmt.Fprintf(t, "%s\t%s\t%s\t%t\t%s\n", w.Address, w.Balance, w.MinBalance, w.Sufficient, formatTime(w.CheckedAt))
+			}
+			t.Flush()
+			if len(status.Jobs) == 0 {
+				return nil
+			}
+			fmt.Println()
+			t = newTable()
+			fmt.Fprintln(t, "JOB\tFILE\tNAME\tSTATE\tATTEMPTS\tUPDATED\tERROR")
+			for _, job := range status.Jobs {
+				fmt.Fprintf(t, "%d\t%d\t%s\t%s\t%d\t%s\t%s\n", job.ID, job.FileId, job.Filename, job.State, job.Attempts, formatTime(job.UpdatedAt), job.LastError)
+			}
+			t.Flush()
+			return nil
+		},
+	}
+	cmd.Flags().BoolVarP(&all, "all", "a", false, "include finalized upload jobs")
+	return cmd
+}
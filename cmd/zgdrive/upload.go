@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"zgdrive/model"
+
+	"github.com/spf13/cobra"
+)
+
+// uploadResult is the outcome of uploading one local file.
+type uploadResult struct {
+	Path         string `json:"path"`
+	FileId       int64  `json:"file_id,omitempty"`
+	Hash         string `json:"hash,omitempty"`
+	JobId        int64  `json:"job_id,omitempty"`
+	Deduplicated bool   `json:"deduplicated,omitempty"`
+	// State is the upload job's state, finalized when the content was
+	// already stored
+	State string `json:"state,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// uploadResponse is the body of POST /upload.
+type uploadResponse struct {
+	FileId       int64  `json:"fileId"`
+	Hash         string `json:"hash"`
+	JobId        int64  `json:"jobId"`
+	Deduplicated bool   `json:"deduplicated"`
+}
+
+// localFile is a file to upload and the remote folder it goes to.
+type localFile struct {
+	path     string
+	folderId int64
+}
+
+func newUploadCommand(opts *options) *cobra.Command {
+	var folder string
+	var wait bool
+	cmd := &cobra.Command{
+		Use:   "upload <path>...",
+		Short: "Upload files and directories",
+		Long: `Upload files to a folder, the root by default. Paths may be globs like
+'*.pdf', which are expanded even when quoted. A directory is uploaded with
+everything in it, recreating its tree under the folder; folders that
+already exist are reused.
+
+With --wait the command returns once every upload is finalized on 0G or
+has failed.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			c, err := opts.client()
+			if err != nil {
+				return err
+			}
+			folderId, err := c.folderId(ctx, folder)
+			if err != nil {
+				return err
+			}
+			paths, err := expandPaths(args)
+			if err != nil {
+				return err
+			}
+			files, err := c.collectFiles(ctx, paths, folderId)
+			if err != nil {
+				return err
+			}
+
+			results := make([]uploadResult, 0, len(files))
+			failed := 0
+			for _, f := range files {
+				result := c.uploadFile(ctx, f)
+				if result.Error != "" {
+					failed++
+				}
+				if !opts.json && !wait {
+					printUploadResult(result)
+				}
+				results = append(results, result)
+			}
+			if wait {
+				err = c.waitForUploads(ctx, results)
+				if err != nil {
+					return err
+				}
+				for _, result := range results {
+					if result.State == model.UploadJobFailed {
+						failed++
+					}
+					if !opts.json {
+						printUploadResult(result)
+					}
+				}
+			}
+
+			if opts.json {
+				err = printJSON(results)
+				if err != nil {
+					return err
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d uploads failed", failed, len(results))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&folder, "folder", "f", "/", "folder path or ID to upload to")
+	cmd.Flags().BoolVarP(&wait, "wait", "w", false, "wait until the uploads are finalized")
+	return cmd
+}
+
+// expandPaths expands the globs among args. Arguments without glob
+// characters must exist.
+func expandPaths(args []string) ([]string, error) {
+	var paths []string
+	for _, arg := range args {
+		if !strings.ContainsAny(arg, "*?[") {
+			_, err := os.Stat(arg)
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, arg)
+			continue
+		}
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", arg, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%s: no matches", arg)
+		}
+		paths = append(paths, matches...)
+	}
+	return paths, nil
+}
+
+// collectFiles lists the files to upload under paths, creating the remote
+// folders for the directories among them inside folderId.
+func (c *client) collectFiles(ctx context.Context, paths []string, folderId int64) ([]localFile, error) {
+	var files []localFile
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, localFile{path: path, folderId: folderId})
+			continue
+		}
+
+		// remote folder of each local directory under path
+		folders := map[string]int64{}
+		path = filepath.Clean(path)
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() {
+				if d.Type().IsRegular() {
+					files = append(files, localFile{path: p, folderId: folders[filepath.Dir(p)]})
+				}
+				return nil
+			}
+			parentId, name := folderId, filepath.Base(p)
+			if p != path {
+				parentId = folders[filepath.Dir(p)]
+			} else if abs, err := filepath.Abs(p); err == nil {
+				// so that . is uploaded under the directory's name
+				name = filepath.Base(abs)
+			}
+			id, err := c.ensureFolder(ctx, parentId, name)
+			if err != nil {
+				return fmt.Errorf("creating folder for %s: %w", p, err)
+			}
+			folders[p] = id
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// ensureFolder returns the ID of the folder called name in parentId,
+// creating it when there is none.
+func (c *client) ensureFolder(ctx context.Context, parentId int64, name string) (int64, error) {
+	var contents model.FolderContents
+	err := c.call(ctx, http.MethodGet, "/folders/"+strconv.FormatInt(parentId, 10), nil, &contents)
+	if err != nil {
+		return 0, err
+	}
+	for _, folder := range contents.Folders {
+		if folder.Name == name {
+			return folder.ID, nil
+		}
+	}
+
+	var folder model.Folder
+	err = c.call(ctx, http.MethodPost, "/folders", map[string]any{
+		"name":      name,
+		"parent_id": parentId,
+	}, &folder)
+	if err != nil {
+		return 0, err
+	}
+	return folder.ID, nil
+}
+
+// uploadFile sends one file to POST /upload. Failures are reported in the
+// result so the remaining files are still uploaded.
+func (c *client) uploadFile(ctx context.Context, f localFile) uploadResult {
+	result := uploadResult{Path: f.path}
+	resp, err := c.postFile(ctx, f)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.FileId = resp.FileId
+	result.Hash = resp.Hash
+	result.JobId = resp.JobId
+	result.Deduplicated = resp.Deduplicated
+	result.State = model.UploadJobQueued
+	if resp.Deduplicated {
+		result.State = model.UploadJobFinalized
+	}
+	return result
+}
+
+// postFile streams f as a multipart form, without reading it into memory.
+func (c *client) postFile(ctx context.Context, f localFile) (uploadResponse, error) {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return uploadResponse{}, err
+	}
+	defer file.Close()
+
+	pr, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+	go func() {
+		err := form.WriteField("folder_id", strconv.FormatInt(f.folderId, 10))
+		if err == nil {
+			var part io.Writer
+			part, err = form.CreateFormFile("file", filepath.Base(f.path))
+			if err == nil {
+				_, err = io.Copy(part, file)
+			}
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	resp, err := c.do(ctx, http.MethodPost, "/upload", pr, form.FormDataContentType())
+	// unblocks the writer when the request failed before reading the body
+	pr.Close()
+	if err != nil {
+		return uploadResponse{}, err
+	}
+	defer resp.Body.Close()
+	var body uploadResponse
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	return body, err
+}
+
+// waitForUploads polls the upload jobs until those of results are
+// finalized or failed, and records their final state.
+func (c *client) waitForUploads(ctx context.Context, results []uploadResult) error {
+	for {
+		var jobs []model.UploadJob
+		err := c.call(ctx, http.MethodGet, "/uploadJobs", nil, &jobs)
+		if err != nil {
+			return err
+		}
+		states := map[int64]model.UploadJob{}
+		for _, job := range jobs {
+			states[job.ID] = job
+		}
+
+		pending := false
+		for i := range results {
+			r := &results[i]
+			if r.JobId == 0 || r.Error != "" {
+				continue
+			}
+			job, ok := states[r.JobId]
+			if !ok {
+				continue
+			}
+			r.State = job.State
+			if job.State == model.UploadJobFailed {
+				r.Error = job.LastError
+			}
+			if job.State != model.UploadJobFinalized && job.State != model.UploadJobFailed {
+				pending = true
+			}
+		}
+		if !pending {
+			return nil
+		}
+
+		select {
+		case <-time.After(2 * time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func printUploadResult(r uploadResult) {
+	switch {
+	case r.Error != "":
+		fmt.Fprintf(os.Stderr, "%s: %s\n", r.Path, r.Error)
+	case r.Deduplicated:
+		fmt.Printf("%s: file %d, already stored as %s\n", r.Path, r.FileId, r.Hash)
+	default:
+		fmt.Printf("%s: file %d, %s (job %d)\n", r.Path, r.FileId, r.State, r.JobId)
+	}
+}
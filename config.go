@@ -0,0 +1,103 @@
+package main
+
+import (
+	"log"
+	"math/big"
+	"os"
+	"strconv"
+	"time"
+	"zgdrive/model"
+)
+
+// databaseURL is where the metadata is kept: DATABASE_URL when set, a
+// postgres:// URL or a SQLite file, and ./files.db otherwise.
+func databaseURL() string {
+	if url := os.Getenv("DATABASE_URL"); url != "" {
+		return url
+	}
+	return "./files.db"
+}
+
+// envString reads a setting from the environment, falling back to def when
+// it is unset.
+func envString(name string, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// envInt reads an integer setting from the environment, falling back to def
+// when it is unset.
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", name, err)
+	}
+	return n
+}
+
+// envWei reads an amount in wei from the environment, falling back to def
+// when it is unset.
+func envWei(name string, def *big.Int) *big.Int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, ok := new(big.Int).SetString(v, 10)
+	if !ok || n.Sign() < 0 {
+		log.Fatalf("Invalid %s: %s", name, v)
+	}
+	return n
+}
+
+// envDuration reads a duration such as "24h" from the environment, falling
+// back to def when it is unset.
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", name, err)
+	}
+	return d
+}
+
+// instanceId names this server among the instances sharing a database,
+// from INSTANCE_ID or the hostname. It must stay the same across restarts,
+// since an instance only recovers the work it claimed under its own name.
+func instanceId() string {
+	if id := os.Getenv("INSTANCE_ID"); id != "" {
+		return id
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Fatalf("INSTANCE_ID is not set and the hostname is unknown: %v", err)
+	}
+	return hostname
+}
+
+// defaultQuota reads the limits of accounts without limits of their own,
+// 0 being unlimited.
+func defaultQuota() model.QuotaLimits {
+	return model.QuotaLimits{
+		MaxBytes:         int64(envInt("QUOTA_MAX_BYTES", 0)),
+		MaxFiles:         int64(envInt("QUOTA_MAX_FILES", 0)),
+		MaxUploadsPerDay: int64(envInt("QUOTA_UPLOADS_PER_DAY", 0)),
+	}
+}
+
+// defaultWalletQuota reads the daily limits of paying wallets without
+// limits of their own, 0 being unlimited.
+func defaultWalletQuota() model.WalletLimits {
+	return model.WalletLimits{
+		MaxBytesPerDay:       int64(envInt("WALLET_QUOTA_BYTES_PER_DAY", 0)),
+		MaxSubmissionsPerDay: int64(envInt("WALLET_QUOTA_SUBMISSIONS_PER_DAY", 0)),
+	}
+}
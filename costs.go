@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+	"zgdrive/model"
+	"zgdrive/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func registerCostRoutes(api gin.IRouter, ctx context.Context, dbservice services.Store, w *workers) {
+	// @Summary Estimate upload cost
+	// @Description Estimate the storage fee and gas of uploading a file, priced by the flow contract at FLOW_ADDR. Pass the size of a file, a resumable upload or a file that is waiting to be submitted. Encrypted uploads are priced at their encrypted size.
+	// @Produce json
+	// @Param size query int false "File size in bytes"
+	// @Param sessionId query string false "Resumable upload session ID"
+	// @Param fileId query int false "ID of a file waiting to be submitted"
+	// @Success 200 {object} model.CostEstimate
+	// @Failure 400 {object} gin.H "None of size, sessionId or fileId given"
+	// @Failure 404 {object} gin.H "Upload or file not found"
+	// @Router /estimate [get]
+	api.GET("/estimate", func(c *gin.Context) {
+		user := currentUser(c)
+		// files uploaded from now on are encrypted when a master key is set
+		file := model.File{Encrypted: w.encryptor != nil}
+		switch {
+		case c.Query("size") != "":
+			size, err := strconv.ParseInt(c.Query("size"), 10, 64)
+			if err != nil || size < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid size"})
+				return
+			}
+			file.Size = size
+		case c.Query("sessionId") != "":
+			session, err := dbservice.GetUploadSession(ctx, user.ID, c.Query("sessionId"))
+			if err != nil {
+				c.JSON(statusFor(err), gin.H{"error": err.Error()})
+				return
+			}
+			file.Size = session.Size
+		case c.Query("fileId") != "":
+			fileId, err := strconv.ParseInt(c.Query("fileId"), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			file, err = dbservice.GetUserFile(ctx, user.ID, fileId)
+			if err != nil {
+				c.JSON(statusFor(err), gin.H{"error": err.Error()})
+				return
+			}
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "one of size, sessionId or fileId is required"})
+			return
+		}
+
+		estimate, err := w.storage.EstimateCost(ctx, submittedSize(file))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, estimate)
+	})
+
+	// @Summary Get a file's cost
+	// @Description Get what submitting a file to 0G cost, from its transaction receipt. Files that reused content already stored on 0G cost nothing and have no record.
+	// @Produce json
+	// @Param fileId path int true "File ID"
+	// @Success 200 {object} model.FileCost
+	// @Failure 404 {object} gin.H "No cost recorded for the file"
+	// @Router /files/{fileId}/cost [get]
+	api.GET("/files/:fileId/cost", func(c *gin.Context) {
+		fileId, ok := paramInt(c, "fileId")
+		if !ok {
+			return
+		}
+
+		cost, err := dbservice.GetFileCost(ctx, currentUser(c).ID, fileId)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, cost)
+	})
+
+	// @Summary Your cost report
+	// @Description Sum what the signed in user's uploads cost by day
+	// @Produce json
+	// @Param from query string false "First day, YYYY-MM-DD"
+	// @Param to query string false "Last day, YYYY-MM-DD"
+	// @Success 200 {array} model.CostReportRow
+	// @Router /costs [get]
+	api.GET("/costs", func(c *gin.Context) {
+		from, to, ok := reportRange(c)
+		if !ok {
+			return
+		}
+
+		report, err := dbservice.CostReport(ctx, currentUser(c).ID, from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	})
+
+	admin := api.Group("/admin", requireAdmin())
+
+	// @Summary Cost report
+	// @Description Sum what uploads cost by day and user. Admin only.
+	// @Produce json
+	// @Param from query string false "First day, YYYY-MM-DD"
+	// @Param to query string false "Last day, YYYY-MM-DD"
+	// @Param user_id query int false "Only this user"
+	// @Success 200 {array} model.CostReportRow
+	// @Failure 403 {object} gin.H "Not an admin"
+	// @Router /admin/costs [get]
+	admin.GET("/costs", func(c *gin.Context) {
+		from, to, ok := reportRange(c)
+		if !ok {
+			return
+		}
+		var userId int64
+		if v := c.Query("user_id"); v != "" {
+			var err error
+			userId, err = strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		report, err := dbservice.CostReport(ctx, userId, from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	})
+}
+
+// reportRange reads the from and to days of a report, writing the error
+// response if one is malformed.
+func reportRange(c *gin.Context) (string, string, bool) {
+	from, to := c.Query("from"), c.Query("to")
+	for _, day := range []string{from, to} {
+		if day == "" {
+			continue
+		}
+		if _, err := time.Parse(time.DateOnly, day); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "days must be YYYY-MM-DD"})
+			return "", "", false
+		}
+	}
+	return from, to, true
+}
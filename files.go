@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"zgdrive/model"
+	"zgdrive/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func registerFileRoutes(router gin.IRouter, ctx context.Context, dbservice services.Store, w *workers) {
+	// @Summary Delete a file
+	// @Description Delete a file with all its versions. Local copies of its content are removed once no other file references the same root hash.
+	// @Produce json
+	// @Param fileId path int true "ID of any version of the file"
+	// @Success 204
+	// @Failure 404 {object} gin.H "File not found"
+	// @Failure 409 {object} gin.H "File is being uploaded or downloaded"
+	// @Router /files/{fileId} [delete]
+	router.DELETE("/files/:fileId", func(c *gin.Context) {
+		fileId, ok := paramInt(c, "fileId")
+		if !ok {
+			return
+		}
+
+		versions, orphaned, err := dbservice.DeleteFile(ctx, currentUser(c).ID, fileId)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		for _, file := range versions {
+			err = os.Remove(w.layout.StagingPath(file.ID))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				fmt.Println("Error deleting staged file:", err)
+			}
+		}
+		for _, hash := range orphaned {
+			err = os.Remove(w.layout.CachePath(hash))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				fmt.Println("Error deleting cached file:", err)
+			}
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	// @Summary Stream a file
+	// @Description Stream a file straight from 0G, verifying every segment against the file's Merkle root as it arrives. Range requests only fetch the segments they cover, so large files can be seeked in and interrupted downloads resumed. A copy in the download cache is served when there is one.
+	// @Produce octet-stream
+	// @Param fileId path int true "File ID"
+	// @Param Range header string false "Byte range, e.g. bytes=1048576-"
+	// @Success 200 {file} file "Whole file"
+	// @Success 206 {file} file "Requested range"
+	// @Failure 404 {object} gin.H "File not found"
+	// @Failure 409 {object} gin.H "File is not stored on 0G yet"
+	// @Failure 416 {string} string "Range not satisfiable"
+	// @Router /stream/{fileId} [get]
+	stream := func(c *gin.Context) {
+		fileId, ok := paramInt(c, "fileId")
+		if !ok {
+			return
+		}
+
+		file, err := dbservice.GetUserFile(ctx, currentUser(c).ID, fileId)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		// stop fetching segments when the client goes away
+		content, closeContent, err := w.openContent(c.Request.Context(), file)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error(), "fileId": file.ID})
+			return
+		}
+		defer closeContent()
+
+		c.Header("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": file.Filename}))
+		http.ServeContent(c.Writer, c.Request, file.Filename, file.CreatedAt, content)
+	}
+	router.GET("/stream/:fileId", stream)
+	router.HEAD("/stream/:fileId", stream)
+
+	// @Summary List file versions
+	// @Description List every version of the file a file ID belongs to, oldest first
+	// @Produce json
+	// @Param fileId path int true "ID of any version of the file"
+	// @Success 200 {array} model.File
+	// @Failure 404 {object} gin.H "File not found"
+	// @Router /files/{fileId}/versions [get]
+	router.GET("/files/:fileId/versions", func(c *gin.Context) {
+		fileId, ok := paramInt(c, "fileId")
+		if !ok {
+			return
+		}
+
+		versions, err := dbservice.ListVersions(ctx, currentUser(c).ID, fileId)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, versions)
+	})
+
+	// @Summary Download a file version
+	// @Description Queue a download of one version of a file. Its progress and content are available under the version's own file ID.
+	// @Produce json
+	// @Param fileId path int true "ID of any version of the file"
+	// @Param version path int true "Version number"
+	// @Success 202 {object} gin.H "Download queued"
+	// @Failure 404 {object} gin.H "Version not found"
+	// @Failure 503 {object} gin.H "Download queue is full"
+	// @Router /files/{fileId}/versions/{version}/download [get]
+	router.GET("/files/:fileId/versions/:version/download", func(c *gin.Context) {
+		file, ok := versionParam(c, ctx, dbservice)
+		if !ok {
+			return
+		}
+
+		isDone, err := dbservice.CheckIsFileAlreadyDownloaded(ctx, file.OwnerId, file.Hash)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "fileId": file.ID, "status": "error"})
+			return
+		}
+		if isDone {
+			c.JSON(http.StatusOK, gin.H{"message": "File already downloaded or processing. File name: " + file.Filename, "status": "downloaded", "fileId": file.ID, "version": file.Version})
+			return
+		}
+
+		jobId, err := w.queueDownload(ctx, file)
+		if errors.Is(err, errDownloadQueueFull) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error(), "fileId": file.ID, "status": "error"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "fileId": file.ID, "status": "error"})
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{"message": "File queued for download. File name: " + file.Filename, "status": "downloading", "fileId": file.ID, "version": file.Version, "jobId": jobId})
+	})
+
+	// @Summary Restore a file version
+	// @Description Make an older version of a file the current one
+	// @Produce json
+	// @Param fileId path int true "ID of any version of the file"
+	// @Param version path int true "Version number"
+	// @Success 200 {object} model.File
+	// @Failure 404 {object} gin.H "Version not found"
+	// @Router /files/{fileId}/versions/{version}/restore [post]
+	router.POST("/files/:fileId/versions/:version/restore", func(c *gin.Context) {
+		file, ok := versionParam(c, ctx, dbservice)
+		if !ok {
+			return
+		}
+
+		err := dbservice.SetCurrentVersion(ctx, file.ID)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		file.IsCurrent = true
+		file.SetSizeReadable()
+		c.JSON(http.StatusOK, file)
+	})
+}
+
+// versionParam looks up the version named by the fileId and version path
+// parameters, writing the error response if there is none.
+func versionParam(c *gin.Context, ctx context.Context, dbservice services.Store) (model.File, bool) {
+	fileId, ok := paramInt(c, "fileId")
+	if !ok {
+		return model.File{}, false
+	}
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return model.File{}, false
+	}
+
+	file, err := dbservice.GetVersion(ctx, currentUser(c).ID, fileId, version)
+	if err != nil {
+		c.JSON(statusFor(err), gin.H{"error": err.Error()})
+		return model.File{}, false
+	}
+	return file, true
+}
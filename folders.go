@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"zgdrive/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// statusFor maps service errors to HTTP status codes.
+func statusFor(err error) int {
+	switch {
+	case errors.Is(err, sql.ErrNoRows),
+		errors.Is(err, services.ErrObjectNotFound),
+		errors.Is(err, services.ErrNotSubmission):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrInvalidName),
+		errors.Is(err, services.ErrWeakPassword),
+		errors.Is(err, services.ErrInvalidSiweMessage),
+		errors.Is(err, services.ErrInvalidPermission),
+		errors.Is(err, errInvalidImport):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrInvalidCredentials),
+		errors.Is(err, services.ErrInvalidSignature),
+		errors.Is(err, services.ErrInvalidNonce),
+		errors.Is(err, services.ErrSharePassword):
+		return http.StatusUnauthorized
+	case errors.Is(err, services.ErrFolderExists),
+		errors.Is(err, services.ErrFolderNotEmpty),
+		errors.Is(err, services.ErrFolderCycle),
+		errors.Is(err, services.ErrFileBusy),
+		errors.Is(err, errNotStored),
+		errors.Is(err, errNotFinalized),
+		errors.Is(err, services.ErrUserExists):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrQuotaExceeded):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, services.ErrUploadLimit):
+		return http.StatusTooManyRequests
+	case errors.Is(err, services.ErrShareExpired),
+		errors.Is(err, services.ErrShareExhausted):
+		return http.StatusGone
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func paramInt(c *gin.Context, name string) (int64, bool) {
+	v, err := strconv.ParseInt(c.Param(name), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return 0, false
+	}
+	return v, true
+}
+
+type createFolderRequest struct {
+	Name     string `json:"name" binding:"required"`
+	ParentId int64  `json:"parent_id"`
+}
+
+type updateFolderRequest struct {
+	Name     *string `json:"name"`
+	ParentId *int64  `json:"parent_id"`
+}
+
+type moveFileRequest struct {
+	FolderId int64 `json:"folder_id"`
+}
+
+func registerFolderRoutes(router gin.IRouter, ctx context.Context, dbservice services.Store) {
+	// @Summary Create a folder
+	// @Description Create a folder under parent_id, or at the root when parent_id is 0
+	// @Accept json
+	// @Produce json
+	// @Param folder body createFolderRequest true "Folder to create"
+	// @Success 201 {object} model.Folder
+	// @Failure 400 {object} gin.H "Invalid name"
+	// @Failure 404 {object} gin.H "Parent folder not found"
+	// @Failure 409 {object} gin.H "A folder with that name already exists"
+	// @Router /folders [post]
+	router.POST("/folders", func(c *gin.Context) {
+		var req createFolderRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		folder, err := dbservice.CreateFolder(ctx, currentUser(c).ID, req.Name, req.ParentId)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, folder)
+	})
+
+	// @Summary List a folder
+	// @Description List the subfolders and files of a folder. Folder 0 is the root.
+	// @Produce json
+	// @Param folderId path int true "Folder ID"
+	// @Success 200 {object} model.FolderContents
+	// @Failure 404 {object} gin.H "Folder not found"
+	// @Router /folders/{folderId} [get]
+	router.GET("/folders/:folderId", func(c *gin.Context) {
+		folderId, ok := paramInt(c, "folderId")
+		if !ok {
+			return
+		}
+
+		contents, err := dbservice.ListFolder(ctx, currentUser(c).ID, folderId)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, contents)
+	})
+
+	// @Summary Rename or move a folder
+	// @Description Set name to rename the folder and/or parent_id to move it (0 for the root)
+	// @Accept json
+	// @Produce json
+	// @Param folderId path int true "Folder ID"
+	// @Param folder body updateFolderRequest true "Fields to change"
+	// @Success 200 {object} model.Folder
+	// @Failure 404 {object} gin.H "Folder not found"
+	// @Failure 409 {object} gin.H "Name taken or move would create a cycle"
+	// @Router /folders/{folderId} [patch]
+	router.PATCH("/folders/:folderId", func(c *gin.Context) {
+		folderId, ok := paramInt(c, "folderId")
+		if !ok {
+			return
+		}
+		var req updateFolderRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if folderId == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the root folder cannot be changed"})
+			return
+		}
+
+		user := currentUser(c)
+		if req.Name != nil {
+			err := dbservice.RenameFolder(ctx, user.ID, folderId, *req.Name)
+			if err != nil {
+				c.JSON(statusFor(err), gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if req.ParentId != nil {
+			err := dbservice.MoveFolder(ctx, user.ID, folderId, *req.ParentId)
+			if err != nil {
+				c.JSON(statusFor(err), gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		folder, err := dbservice.GetFolder(ctx, user.ID, folderId)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, folder)
+	})
+
+	// @Summary Delete a folder
+	// @Description Delete an empty folder
+	// @Param folderId path int true "Folder ID"
+	// @Success 204
+	// @Failure 404 {object} gin.H "Folder not found"
+	// @Failure 409 {object} gin.H "Folder is not empty"
+	// @Router /folders/{folderId} [delete]
+	router.DELETE("/folders/:folderId", func(c *gin.Context) {
+		folderId, ok := paramInt(c, "folderId")
+		if !ok {
+			return
+		}
+
+		err := dbservice.DeleteFolder(ctx, currentUser(c).ID, folderId)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	// @Summary Move a file
+	// @Description Move a file to another folder (0 for the root)
+	// @Accept json
+	// @Produce json
+	// @Param fileId path int true "File ID"
+	// @Param folder body moveFileRequest true "Target folder"
+	// @Success 200 {object} model.File
+	// @Failure 404 {object} gin.H "File or folder not found"
+	// @Router /files/{fileId}/move [post]
+	router.POST("/files/:fileId/move", func(c *gin.Context) {
+		fileId, ok := paramInt(c, "fileId")
+		if !ok {
+			return
+		}
+		var req moveFileRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user := currentUser(c)
+		err := dbservice.MoveFile(ctx, user.ID, fileId, req.FolderId)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		file, err := dbservice.GetUserFile(ctx, user.ID, fileId)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		file.SetSizeReadable()
+		c.JSON(http.StatusOK, file)
+	})
+
+	// @Summary Resolve a path
+	// @Description Resolve a path like /projects/2026/report.pdf to a folder or a file
+	// @Produce json
+	// @Param path query string true "Path to resolve"
+	// @Success 200 {object} gin.H "type is folder or file, with the matching object"
+	// @Failure 404 {object} gin.H "Path not found"
+	// @Router /resolve [get]
+	router.GET("/resolve", func(c *gin.Context) {
+		folder, file, err := dbservice.ResolvePath(ctx, currentUser(c).ID, c.Query("path"))
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error(), "path": c.Query("path")})
+			return
+		}
+		if file != nil {
+			file.SetSizeReadable()
+			c.JSON(http.StatusOK, gin.H{"type": "file", "file": file})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"type": "folder", "folder": folder})
+	})
+}
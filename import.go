@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"zgdrive/model"
+	"zgdrive/services"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+)
+
+// importRequest names an object uploaded to 0G without zgDrive, by its root
+// hash or by the transaction that submitted it.
+type importRequest struct {
+	RootHash string `json:"root_hash"`
+	TxHash   string `json:"tx_hash"`
+	// Filename defaults to the root hash
+	Filename string `json:"filename"`
+	FolderId int64  `json:"folder_id"`
+}
+
+var (
+	errInvalidImport = errors.New("give either a root hash or a transaction hash, as 0x and 64 hex digits")
+	errNotFinalized  = errors.New("object is not finalized on 0G yet, try again later")
+)
+
+var hashPattern = regexp.MustCompile(`^0x[0-9a-fA-F]{64}$`)
+
+// importObject adds a file for a finalized object that is already on 0G.
+// Nothing is uploaded and the object is served as it is stored.
+func (w *workers) importObject(ctx context.Context, ownerId int64, req importRequest) (model.File, error) {
+	if (req.RootHash == "") == (req.TxHash == "") {
+		return model.File{}, errInvalidImport
+	}
+	var filename string
+	if req.Filename != "" {
+		var err error
+		filename, err = services.CleanFilename(req.Filename)
+		if err != nil {
+			return model.File{}, err
+		}
+	}
+	rootHash := req.RootHash
+	if req.TxHash != "" {
+		if !hashPattern.MatchString(req.TxHash) {
+			return model.File{}, errInvalidImport
+		}
+		var err error
+		rootHash, err = w.storage.SubmissionRoot(ctx, req.TxHash)
+		if err != nil {
+			return model.File{}, err
+		}
+	}
+	if !hashPattern.MatchString(rootHash) {
+		return model.File{}, errInvalidImport
+	}
+	rootHash = common.HexToHash(rootHash).Hex()
+
+	info, err := w.storage.ObjectInfo(ctx, rootHash)
+	if err != nil {
+		return model.File{}, err
+	}
+	// downloads go through the same check as native uploads
+	stored, err := w.storage.CheckFileStatus(ctx, rootHash)
+	if err != nil {
+		return model.File{}, err
+	}
+	if !info.Finalized || !stored {
+		return model.File{}, errNotFinalized
+	}
+
+	if req.FolderId != 0 {
+		_, err = w.db.GetFolder(ctx, ownerId, req.FolderId)
+		if err != nil {
+			return model.File{}, err
+		}
+	}
+	if filename == "" {
+		filename = rootHash
+	}
+	file, err := w.db.AddFile(ctx, model.File{
+		OwnerId:    ownerId,
+		Filename:   filename,
+		FolderId:   req.FolderId,
+		Hash:       rootHash,
+		Size:       info.Size,
+		TxId:       req.TxHash,
+		IsUploaded: true,
+	}, w.defaultQuota)
+	if err != nil {
+		return model.File{}, err
+	}
+	file.SetSizeReadable()
+	return file, nil
+}
+
+func registerImportRoutes(api gin.IRouter, ctx context.Context, w *workers) {
+	// @Summary Import an object from 0G
+	// @Description Add a file for an object uploaded to 0G without zgDrive, such as with the 0g-storage-client CLI, given its root hash or the transaction that submitted it. The object must be finalized. It is listed and downloaded like an upload and counts towards the quota, but is never encrypted.
+	// @Accept json
+	// @Produce json
+	// @Param import body importRequest true "Object to import"
+	// @Success 201 {object} model.File
+	// @Failure 400 {object} gin.H "Neither or both of root_hash and tx_hash given, or an invalid filename"
+	// @Failure 404 {object} gin.H "Object, transaction or folder not found"
+	// @Failure 409 {object} gin.H "Object not finalized yet"
+	// @Failure 413 {object} gin.H "Storage quota exceeded"
+	// @Router /import [post]
+	api.POST("/import", func(c *gin.Context) {
+		var req importRequest
+		err := c.ShouldBindJSON(&req)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		file, err := w.importObject(ctx, currentUser(c).ID, req)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, file)
+	})
+}
+
+const importUsage = `usage: zgdrive import -user <username> [-folder <id>] [-name <filename>] (-root <hash> | -tx <hash>)
+
+Adds a file for an object already stored on 0G, owned by the given user.`
+
+// runImport is the import subcommand, which imports an object without
+// going through the API.
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	username := flags.String("user", "", "")
+	var req importRequest
+	flags.StringVar(&req.RootHash, "root", "", "")
+	flags.StringVar(&req.TxHash, "tx", "", "")
+	flags.StringVar(&req.Filename, "name", "", "")
+	flags.Int64Var(&req.FolderId, "folder", 0, "")
+	err := flags.Parse(args)
+	if err != nil || *username == "" || flags.NArg() > 0 {
+		return fmt.Errorf("%s", importUsage)
+	}
+
+	ctx := context.Background()
+	storage, err := services.NewStorageBackend()
+	if err != nil {
+		return err
+	}
+	dbservice := services.NewDBService(databaseURL())
+	if dbservice == nil {
+		return fmt.Errorf("failed to open database")
+	}
+	defer dbservice.Close()
+
+	user, err := dbservice.GetUserByName(ctx, *username)
+	if err != nil {
+		return fmt.Errorf("getting user %s: %w", *username, err)
+	}
+	w := &workers{db: dbservice, storage: storage, defaultQuota: defaultQuota()}
+	file, err := w.importObject(ctx, user.ID, req)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %s as file %d, %s (%s)\n", file.Hash, file.ID, file.Filename, file.SizeReadable)
+	return nil
+}
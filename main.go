@@ -2,8 +2,11 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math/big"
 	"net/http"
 	"os"
 	"strconv"
@@ -33,131 +36,160 @@ func main() {
 		log.Println("Error loading .env file")
 	}
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			err = runMigrate(os.Args[2:])
+		case "restore":
+			err = runRestore(os.Args[2:])
+		case "import":
+			err = runImport(os.Args[2:])
+		default:
+			err = fmt.Errorf("unknown command %q, want migrate, restore or import", os.Args[1])
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	ctx := context.Background()
-	newFilesChan := make(chan model.File)
-	downloadedFilesChan := make(chan model.File)
-	zgService, err := services.NewZgService()
+	uploadWorkers := envInt("UPLOAD_WORKERS", 2)
+	downloadWorkers := envInt("DOWNLOAD_WORKERS", 4)
+	// uploadJobsChan wakes idle upload workers when a job is queued
+	uploadJobsChan := make(chan struct{}, uploadWorkers)
+	downloadedFilesChan := make(chan downloadRequest, envInt("DOWNLOAD_QUEUE_SIZE", 100))
+	storage, err := services.NewStorageBackend()
 	if err != nil {
-		fmt.Println("Error creating ZgService:", err)
+		fmt.Println("Error creating storage backend:", err)
 		return
 	}
-
-	dbservice := services.NewDBService("./files.db")
-	if dbservice == nil {
-		log.Fatal("Failed to initialize database service")
+	wallets, err := services.NewWalletPool(storage.Wallets(), os.Getenv("WALLET_SELECTION"), storage.PendingTransactions)
+	if err != nil {
+		log.Fatal("Failed to set up wallets: ", err)
 	}
 
-	go func() {
-		for {
-			newFile := <-newFilesChan
-			fmt.Println("New file:", newFile)
-			tx, err := zgService.UploadFile(ctx, newFile.Filename)
-			if err != nil {
-				fmt.Println("Error uploading file:", err)
-				return
-			}
-			fmt.Println("Transaction hash:", tx)
-
-			if tx != "" {
-				dbservice.UpdateTxId(ctx, newFile.ID, tx)
-			}
-		}
-	}()
-
-	go func() {
-		for {
-			downloadedFile := <-downloadedFilesChan
-			fmt.Println("Downloaded file:", downloadedFile)
-			dbservice.NewDownloadedFile(ctx, downloadedFile)
+	layout, err := services.NewLayoutFromEnv()
+	if err != nil {
+		log.Fatal("Failed to initialize data directory: ", err)
+	}
+	err = layout.CleanTemp()
+	if err != nil {
+		log.Fatal("Failed to clean temporary files: ", err)
+	}
 
-			// download file from zgdrive
-			isDone, err := zgService.DownloadFile(ctx, downloadedFile.Filename, downloadedFile.Hash)
-			if err != nil {
-				fmt.Println("Error downloading file:", err)
-				return
-			}
-			if isDone {
-				dbservice.SetProcessing(ctx, downloadedFile.ID)
-				// move file to downloaded directory
-				filePath := fmt.Sprintf("./%s", downloadedFile.Filename)
-				downloadedPath := fmt.Sprintf("./downloads/%s", downloadedFile.Filename)
-				err = os.Rename(filePath, downloadedPath)
-				if err != nil {
-					fmt.Println("Error moving file:", err)
-					return
-				}
-			}
+	encryptor, err := services.NewEncryptorFromEnv()
+	if err != nil {
+		log.Fatal("Failed to initialize encryption: ", err)
+	}
+	if encryptor != nil {
+		fmt.Println("Encryption: enabled")
+	}
 
-		}
-	}()
+	dbservice := services.NewDBService(databaseURL())
+	if dbservice == nil {
+		log.Fatal("Failed to initialize database service")
+	}
 
-	go func() {
-		for {
-			files, err := dbservice.GetExpiredDownloadedFiles(ctx, 1*time.Hour)
-			if err != nil {
-				fmt.Println("Error getting expired downloaded files:", err)
-				return
-			}
-			for _, file := range files {
-				fmt.Println("Expired file:", file)
-				filePath := fmt.Sprintf("./downloads/%s", file.Filename)
-				err = os.Remove(filePath)
-				if err != nil {
-					fmt.Println("Error deleting file:", err)
-					return
-				}
-				dbservice.RemoveDownloadedFile(ctx, file.ID)
-			}
-			time.Sleep(1 * time.Minute)
-		}
-	}()
+	instance := instanceId()
+	recovered, err := dbservice.RecoverUploadJobs(ctx, instance)
+	if err != nil {
+		log.Fatal("Failed to recover upload jobs: ", err)
+	}
+	if recovered > 0 {
+		fmt.Println("Recovered upload jobs:", recovered)
+	}
 
-	go func() {
-		for {
-			files, err := dbservice.GetUnuploadedFiles(ctx)
-			if err != nil {
-				fmt.Println("Error getting unuploaded files:", err)
-				return
-			}
+	interrupted, err := dbservice.ResetInterruptedDownloads(ctx, instance)
+	if err != nil {
+		log.Fatal("Failed to reset interrupted downloads: ", err)
+	}
+	if interrupted > 0 {
+		fmt.Println("Reset interrupted downloads:", interrupted)
+	}
 
-			for _, file := range files {
-				isDone, err := zgService.CheckFileStatus(ctx, file.Hash)
-				if err != nil {
-					fmt.Println("Error checking file status:", err)
-					return
-				}
-				fmt.Printf("File name: %s, status: %t\n", file.Filename, isDone)
-				if isDone {
-					dbservice.SetUploaded(ctx, file.Filename)
-					filePath := fmt.Sprintf("./%s", file.Filename)
-					err = os.Remove(filePath)
-					if err != nil {
-						fmt.Println("Error deleting file:", err)
-						return
-					}
-				}
-			}
-			time.Sleep(10 * time.Second)
+	progress := services.NewProgressTracker()
+	w := &workers{
+		db:                dbservice,
+		storage:           storage,
+		uploadJobs:        uploadJobsChan,
+		downloads:         downloadedFilesChan,
+		downloadLocks:     services.NewKeyedMutex(),
+		layout:            layout,
+		progress:          progress,
+		encryptor:         encryptor,
+		maxUploadAttempts: envInt("UPLOAD_MAX_ATTEMPTS", 5),
+		defaultQuota:      defaultQuota(),
+		walletQuota:       defaultWalletQuota(),
+		instance:          instance,
+		// 0.01 0G by default
+		balances: services.NewWalletMonitor(envWei("WALLET_MIN_BALANCE", big.NewInt(1e16)), storage.Wallets()),
+		wallets:  wallets,
+		// keep this log somewhere that outlives the database
+		backupLog: envString("BACKUP_LOG", "./backups.log"),
+	}
+	supervisor := services.NewSupervisor()
+	for i := 1; i <= uploadWorkers; i++ {
+		supervisor.Go(ctx, fmt.Sprintf("upload-%d", i), 0, w.uploadNext)
+	}
+	for i := 1; i <= downloadWorkers; i++ {
+		supervisor.Go(ctx, fmt.Sprintf("download-%d", i), 0, w.downloadNext)
+	}
+	supervisor.Go(ctx, "expiry", 1*time.Minute, w.sweepExpired)
+	supervisor.Go(ctx, "upload-sessions", 1*time.Hour, w.sweepUploadSessions)
+	supervisor.Go(ctx, "finality", 10*time.Second, w.pollFinality)
+	supervisor.Go(ctx, "wallet", 1*time.Minute, w.checkBalance)
+	if interval := envDuration("BACKUP_INTERVAL", 0); interval > 0 {
+		if encryptor == nil {
+			log.Fatal("Failed to schedule backups: ", services.ErrSnapshotUnencrypted)
 		}
-	}()
+		supervisor.Go(ctx, "backup", interval, w.backupMetadata)
+	}
 
 	router := gin.Default()
 
 	// Enable CORS
 	config := cors.DefaultConfig()
-	config.AllowOrigins = []string{"http://zgdrive.local", "http://localhost:5173", "*"}
+	config.AllowOrigins = services.EnvList("CORS_ORIGINS", []string{"http://zgdrive.local", "http://localhost:5173"})
+	config.AllowCredentials = true
 	config.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type"}
+	config.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization", "Range", "Upload-Offset"}
+	config.ExposeHeaders = []string{"Location", "Upload-Offset", "Upload-Length", "Content-Range", "Accept-Ranges"}
 	router.Use(cors.New(config))
 
 	// HealthCheck godoc
 	// @Summary Health check
-	// @Description Check if the API is running
-	// @Produce plain
-	// @Success 200 {string} string "OK"
+	// @Description Check if the API is running and report the state of each background worker
+	// @Produce json
+	// @Success 200 {object} gin.H "status is ok, or degraded when a worker is backing off or uploads are paused for lack of funds"
 	// @Router /health [get]
 	router.GET("/health", func(c *gin.Context) {
-		c.String(http.StatusOK, "OK")
+		status := "ok"
+		if !supervisor.Healthy() || !w.balances.Sufficient() {
+			status = "degraded"
+		}
+		c.JSON(http.StatusOK, gin.H{"status": status, "workers": supervisor.Health()})
+	})
+
+	// everything but the health check and signing in needs a session
+	api := router.Group("/", requireUser(ctx, dbservice))
+
+	registerAuthRoutes(router, api, ctx, dbservice, siweConfig{
+		domains: services.EnvList("SIWE_DOMAINS", originHosts(config.AllowOrigins)),
+		chainId: int64(envInt("SIWE_CHAIN_ID", 0)),
+	})
+
+	// @Summary Service status
+	// @Description Report the balance of each wallet paying for submissions and whether uploads are running. A wallet below WALLET_MIN_BALANCE, or whose submission failed for lack of funds, isn't used until it is topped up. When no wallet can pay, uploads are paused and queued uploads wait.
+	// @Produce json
+	// @Success 200 {object} gin.H "uploads is running or paused, with the status of each wallet"
+	// @Router /status [get]
+	api.GET("/status", func(c *gin.Context) {
+		uploads := "running"
+		if !w.balances.Sufficient() {
+			uploads = "paused"
+		}
+		c.JSON(http.StatusOK, gin.H{"uploads": uploads, "wallets": w.balances.Statuses()})
 	})
 
 	// @Summary Upload a file
@@ -165,47 +197,88 @@ func main() {
 	// @Accept multipart/form-data
 	// @Produce plain
 	// @Param file formData file true "File to upload"
-	// @Success 200 {object} gin.H "File uploaded successfully. Transaction hash: {hash}"
+	// @Param folder_id formData int false "Folder to put the file in, root when omitted"
+	// @Success 200 {object} gin.H "Content already stored on 0G, the new file points at it"
+	// @Success 202 {object} gin.H "File queued for upload, with its upload job id"
 	// @Failure 400 {object} gin.H "Error getting file"
+	// @Failure 413 {object} gin.H "Storage quota exceeded"
+	// @Failure 429 {object} gin.H "Daily upload limit reached"
 	// @Failure 500 {object} gin.H "Error saving file or adding to database"
 	// @Router /upload [post]
-	router.POST("/upload", func(c *gin.Context) {
+	api.POST("/upload", func(c *gin.Context) {
+		user := currentUser(c)
 		file, err := c.FormFile("file")
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		// store file in local directory
-		err = c.SaveUploadedFile(file, file.Filename)
+		filename, err := services.CleanFilename(file.Filename)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		var folderId int64
+		if v := c.PostForm("folder_id"); v != "" {
+			folderId, err = strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			_, err = dbservice.GetFolder(ctx, user.ID, folderId)
+			if err != nil {
+				c.JSON(statusFor(err), gin.H{"error": err.Error()})
+				return
+			}
+		}
+		err = dbservice.CheckQuota(ctx, user.ID, file.Size, w.defaultQuota)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		// receive the file under a random name, the filename is only metadata
+		tempPath, err := layout.TempPath()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-
-		hash, err := services.FileHash(file.Filename)
+		defer os.Remove(tempPath)
+		err = c.SaveUploadedFile(file, tempPath)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
-		uploadedFile, err := dbservice.AddFile(ctx, file.Filename, hash, file.Size)
+		uploaded, err := w.ingestUpload(ctx, user.ID, tempPath, filename, folderId, file.Size)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
 			return
 		}
-		newFilesChan <- uploadedFile
+		respondIngested(c, uploaded)
+	})
 
-		c.JSON(http.StatusOK, gin.H{"message": "File uploaded successfully. Transaction hash: " + hash})
+	// @Summary List upload jobs
+	// @Description Get the state of the caller's upload jobs, newest first
+	// @Produce json
+	// @Success 200 {array} model.UploadJob
+	// @Failure 500 {object} gin.H "Error listing upload jobs"
+	// @Router /uploadJobs [get]
+	api.GET("/uploadJobs", func(c *gin.Context) {
+		jobs, err := dbservice.ListUploadJobs(ctx, currentUser(c).ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, jobs)
 	})
 
 	// @Summary List all files
-	// @Description Get a list of all files in the system
+	// @Description Get the current version of every file of the caller
 	// @Produce json
 	// @Success 200 {array} model.File
 	// @Failure 500 {string} string "Error listing files"
 	// @Router /list [get]
-	router.GET("/list", func(c *gin.Context) {
-		files, err := dbservice.ListFiles(ctx)
+	api.GET("/list", func(c *gin.Context) {
+		files, err := dbservice.ListFiles(ctx, currentUser(c).ID)
 		if err != nil {
 			c.String(http.StatusInternalServerError, "Error listing files: %v", err)
 			return
@@ -217,26 +290,27 @@ func main() {
 	// @Description Initiate download of a file by its ID
 	// @Produce plain
 	// @Param fileId path int true "File ID"
-	// @Success 200 {object} gin.H "File downloaded successfully. File name: {filename}"
+	// @Success 200 {object} gin.H "File already downloaded or processing"
+	// @Success 202 {object} gin.H "File queued for download, with its download job id"
 	// @Failure 400 {object} gin.H "Invalid file id"
 	// @Failure 500 {object} gin.H "Error getting file by id or checking download status"
+	// @Failure 503 {object} gin.H "Download queue is full"
 	// @Router /download/{fileId} [get]
-	router.GET("/download/:fileId", func(c *gin.Context) {
+	api.GET("/download/:fileId", func(c *gin.Context) {
 		fileId := c.Param("fileId")
-		fmt.Println("File ID:", fileId)
 		fileIdInt, err := strconv.ParseInt(fileId, 10, 64)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "fileId": fileIdInt, "status": "error"})
 			return
 		}
 
-		file, err := dbservice.GetFileById(ctx, fileIdInt)
+		file, err := dbservice.GetUserFile(ctx, currentUser(c).ID, fileIdInt)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "fileId": fileIdInt, "status": "error"})
+			c.JSON(statusFor(err), gin.H{"error": err.Error(), "fileId": fileIdInt, "status": "error"})
 			return
 		}
 
-		isDone, err := dbservice.CheckIsFileAlreadyDownloaded(ctx, file.Hash)
+		isDone, err := dbservice.CheckIsFileAlreadyDownloaded(ctx, file.OwnerId, file.Hash)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "fileId": file.ID, "status": "error"})
 			return
@@ -246,9 +320,17 @@ func main() {
 			return
 		}
 
-		downloadedFilesChan <- file
+		jobId, err := w.queueDownload(ctx, file)
+		if errors.Is(err, errDownloadQueueFull) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error(), "fileId": file.ID, "status": "error"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "fileId": file.ID, "status": "error"})
+			return
+		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "File downloaded successfully. File name: " + file.Filename, "status": "downloading", "fileId": file.ID})
+		c.JSON(http.StatusAccepted, gin.H{"message": "File queued for download. File name: " + file.Filename, "status": "downloading", "fileId": file.ID, "jobId": jobId})
 	})
 
 	// @Summary Check download status
@@ -257,7 +339,7 @@ func main() {
 	// @Param fileId path int true "File ID"
 	// @Success 200 {object} gin.H "Download status: {status}"
 	// @Router /downloadStatus/{fileId} [get]
-	router.GET("/downloadStatus/:fileId", func(c *gin.Context) {
+	api.GET("/downloadStatus/:fileId", func(c *gin.Context) {
 		fileId := c.Param("fileId")
 		fileIdInt, err := strconv.ParseInt(fileId, 10, 64)
 		if err != nil {
@@ -265,28 +347,67 @@ func main() {
 			return
 		}
 
-		file, err := dbservice.GetFileById(ctx, fileIdInt)
+		file, err := dbservice.GetUserFile(ctx, currentUser(c).ID, fileIdInt)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "fileId": fileIdInt, "status": "error"})
+			c.JSON(statusFor(err), gin.H{"error": err.Error(), "fileId": fileIdInt, "status": "error"})
 			return
 		}
 
-		isDone, err := dbservice.CheckDownloadStatus(ctx, file.Hash)
+		isDone, err := dbservice.CheckDownloadStatus(ctx, file.OwnerId, file.Hash)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "fileId": fileIdInt, "status": "error"})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"fileId": fileIdInt, "status": isDone})
+		resp := gin.H{"fileId": fileIdInt, "status": isDone}
+		if p, ok := progress.Get(model.ProgressDownload, fileIdInt); ok {
+			resp["progress"] = p
+		}
+		c.JSON(http.StatusOK, resp)
+	})
+
+	// @Summary Transfer progress events
+	// @Description Server-Sent Events stream of the caller's upload and download progress. Active transfers are sent on connect, then every update as a "progress" event.
+	// @Produce text/event-stream
+	// @Success 200 {object} model.Progress
+	// @Router /events [get]
+	api.GET("/events", func(c *gin.Context) {
+		user := currentUser(c)
+		updates, unsubscribe := progress.Subscribe()
+		defer unsubscribe()
+
+		for _, p := range progress.Snapshot() {
+			if p.OwnerId == user.ID {
+				c.SSEvent("progress", p)
+			}
+		}
+		c.Writer.Flush()
+
+		keepAlive := time.NewTicker(15 * time.Second)
+		defer keepAlive.Stop()
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case p := <-updates:
+				if p.OwnerId == user.ID {
+					c.SSEvent("progress", p)
+				}
+				return true
+			case <-keepAlive.C:
+				c.SSEvent("ping", time.Now().Unix())
+				return true
+			case <-c.Request.Context().Done():
+				return false
+			}
+		})
 	})
 
 	// @Summary List all downloaded files
-	// @Description Get a list of all downloaded files
+	// @Description Get the caller's downloaded files
 	// @Produce json
 	// @Success 200 {array} model.File
 	// @Failure 500 {object} gin.H "Error listing downloaded files"
 	// @Router /downloaded [get]
-	router.GET("/downloaded", func(c *gin.Context) {
-		files, err := dbservice.ListDownloadedFiles(ctx)
+	api.GET("/downloaded", func(c *gin.Context) {
+		files, err := dbservice.ListDownloadedFiles(ctx, currentUser(c).ID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -301,7 +422,7 @@ func main() {
 	// @Success 200 {object} gin.H "File downloaded successfully. File name: {filename}"
 	// @Failure 400 {object} gin.H "Invalid file id"
 	// @Failure 500 {object} gin.H "Error getting file by id"
-	router.GET("/downloaded/:fileId", func(c *gin.Context) {
+	api.GET("/downloaded/:fileId", func(c *gin.Context) {
 		fileId := c.Param("fileId")
 		fileIdInt, err := strconv.ParseInt(fileId, 10, 64)
 		if err != nil {
@@ -309,18 +430,25 @@ func main() {
 			return
 		}
 
-		file, err := dbservice.GetFileById(ctx, fileIdInt)
+		file, err := dbservice.GetUserFile(ctx, currentUser(c).ID, fileIdInt)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
 			return
 		}
-		fmt.Println("File:", file)
 
-		// serve file from downloaded directory
-		filePath := fmt.Sprintf("./downloads/%s", file.Filename)
-		c.File(filePath)
+		// serve file from the download cache under its display name
+		c.FileAttachment(layout.CachePath(file.Hash), file.Filename)
 	})
 
+	registerFolderRoutes(api, ctx, dbservice)
+	registerFileRoutes(api, ctx, dbservice, w)
+	registerUploadRoutes(api, ctx, dbservice, w)
+	registerShareRoutes(router, api, ctx, dbservice, w)
+	registerQuotaRoutes(api, ctx, dbservice, w)
+	registerCostRoutes(api, ctx, dbservice, w)
+	registerBackupRoutes(api, ctx, dbservice)
+	registerImportRoutes(api, ctx, w)
+
 	// Add Swagger documentation route
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"zgdrive/services"
+)
+
+const migrateUsage = `usage: zgdrive migrate [command]
+
+commands:
+  up [version]   apply migrations up to version, or all of them (default)
+  down [steps]   roll back the last steps migrations, 1 by default
+  status         list migrations and whether they are applied`
+
+// runMigrate is the migrate subcommand, which changes the database schema
+// without starting the server.
+func runMigrate(args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	if len(args) > 2 {
+		return fmt.Errorf("too many arguments\n%s", migrateUsage)
+	}
+	var n int
+	if len(args) == 2 {
+		var err error
+		n, err = strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid number %q\n%s", args[1], migrateUsage)
+		}
+	}
+
+	ctx := context.Background()
+	dbservice, err := services.OpenDBService(databaseURL())
+	if err != nil {
+		return err
+	}
+	defer dbservice.Close()
+	current, err := dbservice.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "up":
+		target := services.LatestSchemaVersion()
+		if len(args) == 2 {
+			target = n
+		}
+		if target < current {
+			return fmt.Errorf("schema is at version %d, use down to roll back", current)
+		}
+		err = dbservice.MigrateTo(ctx, target)
+	case "down":
+		steps := 1
+		if len(args) == 2 {
+			steps = n
+		}
+		err = dbservice.MigrateTo(ctx, max(current-steps, 0))
+	case "status":
+		return printMigrations(ctx, dbservice, current)
+	default:
+		return fmt.Errorf("unknown command %q\n%s", command, migrateUsage)
+	}
+	if err != nil {
+		return err
+	}
+
+	current, err = dbservice.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Schema is at version %d\n", current)
+	return nil
+}
+
+func printMigrations(ctx context.Context, dbservice services.Store, current int) error {
+	migrations, err := dbservice.Migrations(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Schema is at version %d, this build migrates to %d\n", current, services.LatestSchemaVersion())
+	for _, m := range migrations {
+		state := "pending"
+		if m.AppliedAt != nil {
+			state = "applied " + m.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%04d  %-30s %s\n", m.Version, m.Name, state)
+	}
+	if current > services.LatestSchemaVersion() {
+		fmt.Println("The database was migrated by a newer build, which is needed to run or roll it back.")
+	}
+	return nil
+}
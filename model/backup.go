@@ -0,0 +1,33 @@
+package model
+
+import "time"
+
+// Backup is a snapshot of the metadata that was uploaded to 0G. The
+// database can be rebuilt from RootHash.
+type Backup struct {
+	ID            int64  `json:"id"`
+	RootHash      string `json:"root_hash"`
+	TxId          string `json:"tx_id"`
+	Wallet        string `json:"wallet"`
+	Size          int64  `json:"size"`
+	Encrypted     bool   `json:"encrypted"`
+	SchemaVersion int    `json:"schema_version"`
+	// Digest is the SHA-256 of the snapshot contents, to skip backups of
+	// unchanged metadata
+	Digest    string    `json:"digest"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Snapshot is the metadata catalog: the rows of every table needed to find
+// the files on 0G again, as of SchemaVersion.
+type Snapshot struct {
+	Format        int             `json:"format"`
+	SchemaVersion int             `json:"schema_version"`
+	Tables        []SnapshotTable `json:"tables"`
+}
+
+type SnapshotTable struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Rows    [][]any  `json:"rows"`
+}
@@ -0,0 +1,48 @@
+package model
+
+import "time"
+
+// Amounts are in wei, as decimal strings since they don't fit in 64 bits.
+
+// CostEstimate is the projected price of submitting Size bytes to 0G: the
+// storage fee paid to the flow contract per 256 byte sector, plus gas.
+type CostEstimate struct {
+	Size           int64  `json:"size"`
+	Sectors        int64  `json:"sectors"`
+	PricePerSector string `json:"price_per_sector"`
+	StorageFee     string `json:"storage_fee"`
+	GasLimit       uint64 `json:"gas_limit"`
+	GasPrice       string `json:"gas_price"`
+	GasFee         string `json:"gas_fee"`
+	Total          string `json:"total"`
+}
+
+// TxCost is what a submission transaction cost according to its receipt.
+type TxCost struct {
+	TxId       string `json:"tx_id"`
+	StorageFee string `json:"storage_fee"`
+	GasUsed    uint64 `json:"gas_used"`
+	GasPrice   string `json:"gas_price"`
+	GasFee     string `json:"gas_fee"`
+	Total      string `json:"total"`
+}
+
+// FileCost is the cost of the submission that stored a file.
+type FileCost struct {
+	FileId  int64  `json:"file_id"`
+	OwnerId int64  `json:"owner_id"`
+	Wallet  string `json:"wallet,omitempty"`
+	TxCost
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CostReportRow sums what one user spent on one day.
+type CostReportRow struct {
+	Day        string `json:"day"`
+	UserId     int64  `json:"user_id"`
+	Username   string `json:"username"`
+	Files      int64  `json:"files"`
+	StorageFee string `json:"storage_fee"`
+	GasFee     string `json:"gas_fee"`
+	Total      string `json:"total"`
+}
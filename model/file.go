@@ -6,14 +6,24 @@ import (
 )
 
 type File struct {
-	ID           int64     `json:"id"`
-	Filename     string    `json:"filename"`
-	Hash         string    `json:"hash"`
-	Size         int64     `json:"size"`
-	SizeReadable string    `json:"size_readable"`
-	TxId         string    `json:"tx_id"`
-	IsUploaded   bool      `json:"is_uploaded"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID           int64  `json:"id"`
+	OwnerId      int64  `json:"owner_id"`
+	Filename     string `json:"filename"`
+	FolderId     int64  `json:"folder_id"`
+	Hash         string `json:"hash"`
+	Size         int64  `json:"size"`
+	SizeReadable string `json:"size_readable"`
+	TxId         string `json:"tx_id"`
+	// Wallet is the address that paid for the submission, empty when the
+	// content was already stored
+	Wallet     string    `json:"wallet,omitempty"`
+	IsUploaded bool      `json:"is_uploaded"`
+	Encrypted  bool      `json:"encrypted"`
+	LogicalId  int64     `json:"logical_id"`
+	Version    int       `json:"version"`
+	IsCurrent  bool      `json:"is_current"`
+	WrappedKey string    `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
 }
 
 func (f *File) SetSizeReadable() {
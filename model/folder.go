@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+// Folder is a node in the folder tree. ParentId is 0 for top-level folders.
+type Folder struct {
+	ID        int64     `json:"id"`
+	OwnerId   int64     `json:"owner_id"`
+	Name      string    `json:"name"`
+	ParentId  int64     `json:"parent_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type FolderContents struct {
+	Folder  Folder   `json:"folder"`
+	Folders []Folder `json:"folders"`
+	Files   []File   `json:"files"`
+}
@@ -0,0 +1,11 @@
+package model
+
+import "time"
+
+// Migration is a schema migration and whether it has been applied to the
+// database.
+type Migration struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
@@ -0,0 +1,10 @@
+package model
+
+// ObjectInfo is what the storage nodes know about an object on 0G.
+type ObjectInfo struct {
+	RootHash string `json:"root_hash"`
+	Size     int64  `json:"size"`
+	// TxSeq is the position of the object's submission in the flow
+	TxSeq     uint64 `json:"tx_seq"`
+	Finalized bool   `json:"finalized"`
+}
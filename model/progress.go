@@ -0,0 +1,40 @@
+package model
+
+import "time"
+
+const (
+	ProgressUpload   = "upload"
+	ProgressDownload = "download"
+)
+
+const (
+	PhaseQueued        = "queued"
+	PhaseEncrypting    = "encrypting"
+	PhaseHashing       = "hashing"
+	PhaseTxSubmission  = "tx_submission"
+	PhaseSegmentUpload = "segment_upload"
+	PhaseFinalityWait  = "finality_wait"
+	PhaseDownloading   = "downloading"
+	PhaseDone          = "done"
+	PhaseFailed        = "failed"
+)
+
+type Progress struct {
+	Kind             string    `json:"kind"`
+	OwnerId          int64     `json:"-"`
+	FileId           int64     `json:"file_id"`
+	JobId            int64     `json:"job_id"`
+	Filename         string    `json:"filename"`
+	Phase            string    `json:"phase"`
+	BytesTotal       int64     `json:"bytes_total"`
+	BytesTransferred int64     `json:"bytes_transferred"`
+	SegmentsTotal    uint64    `json:"segments_total"`
+	SegmentsDone     uint64    `json:"segments_done"`
+	Error            string    `json:"error,omitempty"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// Finished reports whether the transfer reached a terminal phase.
+func (p *Progress) Finished() bool {
+	return p.Phase == PhaseDone || p.Phase == PhaseFailed
+}
@@ -0,0 +1,38 @@
+package model
+
+// QuotaLimits caps what a user may store. A limit of 0 is unlimited.
+type QuotaLimits struct {
+	MaxBytes         int64 `json:"max_bytes"`
+	MaxFiles         int64 `json:"max_files"`
+	MaxUploadsPerDay int64 `json:"max_uploads_per_day"`
+}
+
+// Quota is the limits of one user and how much of them is used. Custom is
+// set when an admin changed the user's limits from the defaults.
+type Quota struct {
+	UserId   int64  `json:"user_id"`
+	Username string `json:"username"`
+	QuotaLimits
+	Custom       bool  `json:"custom"`
+	UsedBytes    int64 `json:"used_bytes"`
+	Files        int64 `json:"files"`
+	UploadsToday int64 `json:"uploads_today"`
+}
+
+// WalletLimits caps what a paying wallet may submit in a day, in bytes sent
+// to 0G and in submissions. A limit of 0 is unlimited.
+type WalletLimits struct {
+	MaxBytesPerDay       int64 `json:"max_bytes_per_day"`
+	MaxSubmissionsPerDay int64 `json:"max_submissions_per_day"`
+}
+
+// WalletQuota is the limits of one paying wallet and how much of them is
+// used today. Custom is set when an admin changed the wallet's limits from
+// the defaults.
+type WalletQuota struct {
+	Wallet string `json:"wallet"`
+	WalletLimits
+	Custom           bool  `json:"custom"`
+	BytesToday       int64 `json:"bytes_today"`
+	SubmissionsToday int64 `json:"submissions_today"`
+}
@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+const (
+	SharePermissionView     = "view"
+	SharePermissionDownload = "download"
+)
+
+// Share is a public link to one file version. A view link opens the file
+// in the browser, a download link saves it. MaxDownloads is 0 when the link
+// can be used any number of times. Downloads are counted by the bytes
+// served, a download for every file size's worth.
+type Share struct {
+	ID           int64      `json:"id"`
+	Token        string     `json:"token"`
+	FileId       int64      `json:"file_id"`
+	Filename     string     `json:"filename"`
+	Permission   string     `json:"permission"`
+	HasPassword  bool       `json:"has_password"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	MaxDownloads int64      `json:"max_downloads"`
+	Downloads    int64      `json:"downloads"`
+	BytesServed  int64      `json:"bytes_served"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
@@ -0,0 +1,22 @@
+package model
+
+import "time"
+
+const (
+	UploadJobQueued     = "queued"
+	UploadJobSubmitting = "submitting"
+	UploadJobSubmitted  = "submitted"
+	UploadJobFinalized  = "finalized"
+	UploadJobFailed     = "failed"
+)
+
+type UploadJob struct {
+	ID        int64     `json:"id"`
+	FileId    int64     `json:"file_id"`
+	Filename  string    `json:"filename"`
+	State     string    `json:"state"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
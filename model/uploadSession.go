@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+// UploadSession is a resumable upload. Chunks are appended at Offset until
+// it reaches Size, then the session is finished and the file is queued
+// like a regular upload.
+type UploadSession struct {
+	ID        string    `json:"id"`
+	Filename  string    `json:"filename"`
+	FolderId  int64     `json:"folder_id"`
+	Size      int64     `json:"size"`
+	Offset    int64     `json:"offset"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
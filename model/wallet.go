@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+// WalletStatus is the last known balance of a wallet that pays for
+// submissions. Amounts are in wei. Sufficient is false when the balance
+// fell below MinBalance or a submission failed for lack of funds; the
+// wallet isn't used until it is true again.
+type WalletStatus struct {
+	Address    string    `json:"address"`
+	Balance    string    `json:"balance"`
+	MinBalance string    `json:"min_balance"`
+	Sufficient bool      `json:"sufficient"`
+	Error      string    `json:"error,omitempty"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+const (
+	WorkerRunning    = "running"
+	WorkerBackingOff = "backing_off"
+	WorkerStopped    = "stopped"
+)
+
+type WorkerHealth struct {
+	Name                string    `json:"name"`
+	State               string    `json:"state"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastError           string    `json:"last_error,omitempty"`
+	LastErrorAt         time.Time `json:"last_error_at"`
+	NextRetryAt         time.Time `json:"next_retry_at"`
+}
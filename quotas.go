@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"zgdrive/model"
+	"zgdrive/services"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+)
+
+// updateQuotaRequest sets a user's limits. Omitted or null limits use the
+// defaults from QUOTA_MAX_BYTES, QUOTA_MAX_FILES and QUOTA_UPLOADS_PER_DAY,
+// 0 is unlimited.
+type updateQuotaRequest struct {
+	MaxBytes         *int64 `json:"max_bytes"`
+	MaxFiles         *int64 `json:"max_files"`
+	MaxUploadsPerDay *int64 `json:"max_uploads_per_day"`
+}
+
+// updateWalletQuotaRequest sets a paying wallet's daily limits. Omitted or
+// null limits use the defaults from WALLET_QUOTA_BYTES_PER_DAY and
+// WALLET_QUOTA_SUBMISSIONS_PER_DAY, 0 is unlimited.
+type updateWalletQuotaRequest struct {
+	MaxBytesPerDay       *int64 `json:"max_bytes_per_day"`
+	MaxSubmissionsPerDay *int64 `json:"max_submissions_per_day"`
+}
+
+func registerQuotaRoutes(api gin.IRouter, ctx context.Context, dbservice services.Store, w *workers) {
+	// @Summary Get your quota
+	// @Description Get the storage limits of the signed in user and how much of them is used. A limit of 0 is unlimited.
+	// @Produce json
+	// @Success 200 {object} model.Quota
+	// @Router /quota [get]
+	api.GET("/quota", func(c *gin.Context) {
+		quota, err := dbservice.GetQuota(ctx, currentUser(c).ID, w.defaultQuota)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, quota)
+	})
+
+	admin := api.Group("/admin", requireAdmin())
+
+	// @Summary List quotas
+	// @Description Get the storage limits and usage of every user. Admin only.
+	// @Produce json
+	// @Success 200 {array} model.Quota
+	// @Failure 403 {object} gin.H "Not an admin"
+	// @Router /admin/quotas [get]
+	admin.GET("/quotas", func(c *gin.Context) {
+		quotas, err := dbservice.ListQuotas(ctx, w.defaultQuota)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, quotas)
+	})
+
+	// @Summary Get a quota
+	// @Description Get the storage limits and usage of a user. Admin only.
+	// @Produce json
+	// @Param userId path int true "User ID"
+	// @Success 200 {object} model.Quota
+	// @Failure 403 {object} gin.H "Not an admin"
+	// @Failure 404 {object} gin.H "User not found"
+	// @Router /admin/quotas/{userId} [get]
+	admin.GET("/quotas/:userId", func(c *gin.Context) {
+		userId, ok := paramInt(c, "userId")
+		if !ok {
+			return
+		}
+
+		quota, err := dbservice.GetQuota(ctx, userId, w.defaultQuota)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, quota)
+	})
+
+	// @Summary Set a quota
+	// @Description Change the storage limits of a user. Omitted limits go back to the defaults, 0 is unlimited. Admin only.
+	// @Accept json
+	// @Produce json
+	// @Param userId path int true "User ID"
+	// @Param quota body updateQuotaRequest true "New limits"
+	// @Success 200 {object} model.Quota
+	// @Failure 403 {object} gin.H "Not an admin"
+	// @Failure 404 {object} gin.H "User not found"
+	// @Router /admin/quotas/{userId} [put]
+	admin.PUT("/quotas/:userId", func(c *gin.Context) {
+		userId, ok := paramInt(c, "userId")
+		if !ok {
+			return
+		}
+		var req updateQuotaRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		for _, limit := range []*int64{req.MaxBytes, req.MaxFiles, req.MaxUploadsPerDay} {
+			if limit != nil && *limit < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "limits can't be negative"})
+				return
+			}
+		}
+
+		err := dbservice.SetQuota(ctx, userId, req.MaxBytes, req.MaxFiles, req.MaxUploadsPerDay)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		quota, err := dbservice.GetQuota(ctx, userId, w.defaultQuota)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, quota)
+	})
+
+	// @Summary List wallet quotas
+	// @Description Get the daily limits of every paying wallet and how much of them it used today. Bytes are counted as sent to 0G, after encryption. Admin only.
+	// @Produce json
+	// @Success 200 {array} model.WalletQuota
+	// @Failure 403 {object} gin.H "Not an admin"
+	// @Router /admin/wallet-quotas [get]
+	admin.GET("/wallet-quotas", func(c *gin.Context) {
+		quotas := []model.WalletQuota{}
+		for _, status := range w.balances.Statuses() {
+			quota, err := dbservice.GetWalletQuota(ctx, status.Address, w.walletQuota)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			quotas = append(quotas, quota)
+		}
+		c.JSON(http.StatusOK, quotas)
+	})
+
+	// @Summary Set a wallet quota
+	// @Description Change the daily limits of a paying wallet. Omitted limits go back to the defaults, 0 is unlimited. Admin only.
+	// @Accept json
+	// @Produce json
+	// @Param wallet path string true "Wallet address"
+	// @Param quota body updateWalletQuotaRequest true "New limits"
+	// @Success 200 {object} model.WalletQuota
+	// @Failure 403 {object} gin.H "Not an admin"
+	// @Failure 404 {object} gin.H "Not a paying wallet"
+	// @Router /admin/wallet-quotas/{wallet} [put]
+	admin.PUT("/wallet-quotas/:wallet", func(c *gin.Context) {
+		wallet := ""
+		if common.IsHexAddress(c.Param("wallet")) {
+			address := common.HexToAddress(c.Param("wallet")).Hex()
+			for _, status := range w.balances.Statuses() {
+				if status.Address == address {
+					wallet = address
+				}
+			}
+		}
+		if wallet == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not a paying wallet"})
+			return
+		}
+		var req updateWalletQuotaRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		for _, limit := range []*int64{req.MaxBytesPerDay, req.MaxSubmissionsPerDay} {
+			if limit != nil && *limit < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "limits can't be negative"})
+				return
+			}
+		}
+
+		err := dbservice.SetWalletQuota(ctx, wallet, req.MaxBytesPerDay, req.MaxSubmissionsPerDay)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		quota, err := dbservice.GetWalletQuota(ctx, wallet, w.walletQuota)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, quota)
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"zgdrive/services"
+)
+
+const restoreUsage = `usage: zgdrive restore <root hash>
+
+Rebuilds the database from a metadata snapshot stored on 0G. The database
+named by DATABASE_URL must not hold any files or users yet. The snapshot is
+fetched through IND_RPC and decrypted with ENCRYPTION_MASTER_KEY; no wallet
+is needed.`
+
+// runRestore is the restore subcommand, which downloads a snapshot taken by
+// the backup worker and loads it into a new database.
+func runRestore(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%s", restoreUsage)
+	}
+	rootHash := args[0]
+
+	ctx := context.Background()
+	storage, err := services.NewDownloaderFromEnv()
+	if err != nil {
+		return err
+	}
+	encryptor, err := services.NewEncryptorFromEnv()
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "zgdrive-restore-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot")
+	_, err = storage.DownloadFile(ctx, path, rootHash)
+	if err != nil {
+		return fmt.Errorf("downloading snapshot %s: %w", rootHash, err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	snapshot, err := services.ReadSnapshot(f, encryptor)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	dbservice := services.NewDBService(databaseURL())
+	if dbservice == nil {
+		return fmt.Errorf("failed to open database")
+	}
+	defer dbservice.Close()
+	err = dbservice.RestoreSnapshot(ctx, snapshot)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range snapshot.Tables {
+		if !services.RestoresTable(table.Name) {
+			fmt.Printf("%-12s skipped\n", table.Name)
+			continue
+		}
+		fmt.Printf("%-12s %d rows\n", table.Name, len(table.Rows))
+	}
+	fmt.Printf("Restored snapshot %s taken at schema version %d\n", rootHash, snapshot.SchemaVersion)
+	return nil
+}
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"zgdrive/model"
+)
+
+var (
+	ErrRestoreNotEmpty = errors.New("database already holds files or users, restore into a new database")
+	ErrSnapshotTooNew  = errors.New("snapshot was taken by a newer build, restore it with that build")
+)
+
+// snapshotTables are the tables a snapshot keeps, in the order they are
+// restored. Sessions, sign in nonces, unfinished resumable uploads and the
+// download cache only matter to a running server and are left out. So are
+// upload jobs: they point at staged files the snapshot doesn't hold, and
+// RecoverUploadJobs queues new ones for restored files that never reached
+// 0G.
+var snapshotTables = []string{"users", "folders", "files", "objects", "shares", "user_quotas", "wallet_quotas", "file_costs"}
+
+// droppedSnapshotTables were kept by older snapshots and are skipped when
+// those are restored.
+var droppedSnapshotTables = map[string]bool{"upload_jobs": true}
+
+// RestoresTable reports whether RestoreSnapshot loads the snapshot table
+// name rather than skipping it.
+func RestoresTable(name string) bool {
+	return !droppedSnapshotTables[name]
+}
+
+var snapshotColumn = regexp.MustCompile(`^[a-z_]+$`)
+
+// Snapshot reads the metadata catalog, every table at the same point in
+// time.
+func (d *DBService) Snapshot(ctx context.Context) (model.Snapshot, error) {
+	tx, err := d.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return model.Snapshot{}, err
+	}
+	defer tx.Rollback()
+
+	snapshot := model.Snapshot{Format: SnapshotFormat}
+	err = tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&snapshot.SchemaVersion)
+	if err != nil {
+		return model.Snapshot{}, err
+	}
+
+	for _, name := range snapshotTables {
+		table, err := snapshotTable(ctx, tx, name)
+		if err != nil {
+			return model.Snapshot{}, fmt.Errorf("reading %s: %w", name, err)
+		}
+		snapshot.Tables = append(snapshot.Tables, table)
+	}
+	return snapshot, nil
+}
+
+func snapshotTable(ctx context.Context, tx *dbTx, name string) (model.SnapshotTable, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT * FROM `+name+` ORDER BY 1`)
+	if err != nil {
+		return model.SnapshotTable{}, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return model.SnapshotTable{}, err
+	}
+	table := model.SnapshotTable{Name: name, Columns: columns, Rows: [][]any{}}
+	for rows.Next() {
+		row := make([]any, len(columns))
+		dest := make([]any, len(columns))
+		for i := range row {
+			dest[i] = &row[i]
+		}
+		err := rows.Scan(dest...)
+		if err != nil {
+			return model.SnapshotTable{}, err
+		}
+		for i, v := range row {
+			// text can come back as bytes, which JSON would encode as base64
+			if b, ok := v.([]byte); ok {
+				row[i] = string(b)
+			}
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table, rows.Err()
+}
+
+// RestoreSnapshot loads a snapshot into a database migrated to the latest
+// schema that holds no files or users yet.
+func (d *DBService) RestoreSnapshot(ctx context.Context, snapshot model.Snapshot) error {
+	if snapshot.SchemaVersion > LatestSchemaVersion() {
+		return ErrSnapshotTooNew
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var existing int
+	err = tx.QueryRowContext(ctx, `SELECT (SELECT COUNT(*) FROM users) + (SELECT COUNT(*) FROM files)`).Scan(&existing)
+	if err != nil {
+		return err
+	}
+	if existing > 0 {
+		return ErrRestoreNotEmpty
+	}
+
+	for _, table := range snapshot.Tables {
+		if !RestoresTable(table.Name) {
+			continue
+		}
+		err := restoreTable(ctx, tx, table)
+		if err != nil {
+			return fmt.Errorf("restoring %s: %w", table.Name, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func restoreTable(ctx context.Context, tx *dbTx, table model.SnapshotTable) error {
+	known := false
+	for _, name := range snapshotTables {
+		known = known || name == table.Name
+	}
+	if !known {
+		return fmt.Errorf("unknown table")
+	}
+	hasId := false
+	for _, column := range table.Columns {
+		if !snapshotColumn.MatchString(column) {
+			return fmt.Errorf("invalid column %q", column)
+		}
+		hasId = hasId || column == "id"
+	}
+
+	query := fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s)`, table.Name,
+		strings.Join(table.Columns, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(table.Columns)), ", "))
+	for _, row := range table.Rows {
+		if len(row) != len(table.Columns) {
+			return fmt.Errorf("row has %d values for %d columns", len(row), len(table.Columns))
+		}
+		args := make([]any, len(row))
+		for i, v := range row {
+			args[i] = restoreValue(v)
+		}
+		_, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Postgres doesn't move a sequence past ids inserted explicitly
+	if hasId && tx.dialect == postgresDialect {
+		_, err := tx.ExecContext(ctx, fmt.Sprintf(`SELECT setval(pg_get_serial_sequence('%s', 'id'), MAX(id)) FROM %s`, table.Name, table.Name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreValue turns a number decoded from a snapshot back into the
+// integer it was stored as.
+func restoreValue(v any) any {
+	n, ok := v.(json.Number)
+	if !ok {
+		return v
+	}
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	f, _ := n.Float64()
+	return f
+}
+
+const backupColumns = `id, root_hash, tx_id, wallet, size, encrypted, schema_version, digest, created_at`
+
+func scanBackup(row rowScanner) (model.Backup, error) {
+	var b model.Backup
+	err := row.Scan(&b.ID, &b.RootHash, &b.TxId, &b.Wallet, &b.Size, &b.Encrypted, &b.SchemaVersion, &b.Digest, &b.CreatedAt)
+	return b, err
+}
+
+// AddBackup records a snapshot uploaded to 0G.
+func (d *DBService) AddBackup(ctx context.Context, backup model.Backup) (model.Backup, error) {
+	query := `
+		INSERT INTO backups (root_hash, tx_id, wallet, size, encrypted, schema_version, digest)
+		VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING ` + backupColumns
+	return scanBackup(d.db.QueryRowContext(ctx, query, backup.RootHash, backup.TxId, backup.Wallet, backup.Size,
+		backup.Encrypted, backup.SchemaVersion, backup.Digest))
+}
+
+// ListBackups returns the recorded backups, newest first.
+func (d *DBService) ListBackups(ctx context.Context) ([]model.Backup, error) {
+	rows, err := d.db.QueryContext(ctx, `SELECT `+backupColumns+` FROM backups ORDER BY id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	backups := []model.Backup{}
+	for rows.Next() {
+		backup, err := scanBackup(rows)
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, backup)
+	}
+	return backups, rows.Err()
+}
+
+// LatestBackup returns the last recorded backup, or sql.ErrNoRows.
+func (d *DBService) LatestBackup(ctx context.Context) (model.Backup, error) {
+	return scanBackup(d.db.QueryRowContext(ctx, `SELECT `+backupColumns+` FROM backups ORDER BY id DESC LIMIT 1`))
+}
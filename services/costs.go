@@ -0,0 +1,235 @@
+package services
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"zgdrive/model"
+
+	"github.com/0glabs/0g-storage-client/core"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+)
+
+// The flow contract charges a storage fee per 256 byte sector of the
+// submission. A file is submitted as nodes whose sizes are powers of two
+// chunks, so the fee covers the padded size rather than the file size.
+
+// flowABI and marketABI are the parts of the 0G flow and market contracts
+// needed to price a submission and find what a transaction submitted.
+var (
+	flowABI = mustParseABI(`[
+		{"name": "market", "type": "function", "stateMutability": "view", "inputs": [], "outputs": [{"type": "address"}]},
+		{"name": "submit", "type": "function", "stateMutability": "payable",
+			"inputs": [{"name": "submission", "type": "tuple", "components": [
+				{"name": "length", "type": "uint256"},
+				{"name": "tags", "type": "bytes"},
+				{"name": "nodes", "type": "tuple[]", "components": [
+					{"name": "root", "type": "bytes32"},
+					{"name": "height", "type": "uint256"}
+				]}
+			]}],
+			"outputs": [{"type": "uint256"}, {"type": "bytes32"}, {"type": "uint256"}, {"type": "uint256"}]},
+		{"name": "Submit", "type": "event", "anonymous": false, "inputs": [
+			{"name": "sender", "type": "address", "indexed": true},
+			{"name": "identity", "type": "bytes32", "indexed": true},
+			{"name": "submissionIndex", "type": "uint256", "indexed": false},
+			{"name": "startPos", "type": "uint256", "indexed": false},
+			{"name": "length", "type": "uint256", "indexed": false},
+			{"name": "submission", "type": "tuple", "indexed": false, "components": [
+				{"name": "length", "type": "uint256"},
+				{"name": "tags", "type": "bytes"},
+				{"name": "nodes", "type": "tuple[]", "components": [
+					{"name": "root", "type": "bytes32"},
+					{"name": "height", "type": "uint256"}
+				]}
+			]}
+		]}
+	]`)
+	marketABI = mustParseABI(`[
+		{"name": "pricePerSector", "type": "function", "stateMutability": "view", "inputs": [], "outputs": [{"type": "uint256"}]}
+	]`)
+)
+
+func mustParseABI(definition string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(definition))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+// flowSubmission mirrors the Submission struct of the flow contract.
+type flowSubmission struct {
+	Length *big.Int
+	Tags   []byte
+	Nodes  []flowSubmissionNode
+}
+
+type flowSubmissionNode struct {
+	Root   [32]byte
+	Height *big.Int
+}
+
+// submissionNodes returns the size in chunks of each node a file of size
+// bytes is submitted as, the same way the 0G client splits it.
+func submissionNodes(size int64) []int64 {
+	chunks := (size-1)/core.DefaultChunkSize + 1
+	if size == 0 {
+		chunks = 1
+	}
+	nextPow2 := int64(1)
+	for nextPow2 < chunks {
+		nextPow2 *= 2
+	}
+	padded := nextPow2
+	if nextPow2 != chunks {
+		minChunks := max(nextPow2/16, 1)
+		padded = ((chunks-1)/minChunks + 1) * minChunks
+	}
+
+	var nodes []int64
+	for nodeChunks := nextPow2; padded > 0; nodeChunks /= 2 {
+		if padded >= nodeChunks {
+			padded -= nodeChunks
+			nodes = append(nodes, nodeChunks)
+		}
+	}
+	return nodes
+}
+
+// estimateSubmission returns a submission shaped like the one a file of
+// size bytes produces, for estimating gas, and the sectors it pays for.
+func estimateSubmission(size int64) (flowSubmission, int64) {
+	submission := flowSubmission{Length: big.NewInt(size), Tags: []byte{}}
+	var sectors int64
+	for _, chunks := range submissionNodes(size) {
+		height := 0
+		for n := chunks; n > 1; n /= 2 {
+			height++
+		}
+		submission.Nodes = append(submission.Nodes, flowSubmissionNode{Height: big.NewInt(int64(height))})
+		sectors += chunks
+	}
+	return submission, sectors
+}
+
+func newCostEstimate(size, sectors int64, pricePerSector *big.Int, gasLimit uint64, gasPrice *big.Int) model.CostEstimate {
+	storageFee := new(big.Int).Mul(pricePerSector, big.NewInt(sectors))
+	gasFee := new(big.Int).Mul(gasPrice, new(big.Int).SetUint64(gasLimit))
+	return model.CostEstimate{
+		Size:           size,
+		Sectors:        sectors,
+		PricePerSector: pricePerSector.String(),
+		StorageFee:     storageFee.String(),
+		GasLimit:       gasLimit,
+		GasPrice:       gasPrice.String(),
+		GasFee:         gasFee.String(),
+		Total:          new(big.Int).Add(storageFee, gasFee).String(),
+	}
+}
+
+func newTxCost(txId string, storageFee *big.Int, gasUsed uint64, gasPrice *big.Int) model.TxCost {
+	gasFee := new(big.Int).Mul(gasPrice, new(big.Int).SetUint64(gasUsed))
+	return model.TxCost{
+		TxId:       txId,
+		StorageFee: storageFee.String(),
+		GasUsed:    gasUsed,
+		GasPrice:   gasPrice.String(),
+		GasFee:     gasFee.String(),
+		Total:      new(big.Int).Add(storageFee, gasFee).String(),
+	}
+}
+
+// AddFileCost records what the submission of file cost. A transaction is
+// only recorded once, for the file that submitted it.
+func (d *DBService) AddFileCost(ctx context.Context, file model.File, cost model.TxCost) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO file_costs (tx_id, file_id, owner_id, storage_fee, gas_used, gas_price, gas_fee)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (tx_id) DO NOTHING
+	`, cost.TxId, file.ID, file.OwnerId, cost.StorageFee, cost.GasUsed, cost.GasPrice, cost.GasFee)
+	return err
+}
+
+// GetFileCost returns the recorded cost of a file userId owns, or
+// sql.ErrNoRows when it reused content that was already stored.
+func (d *DBService) GetFileCost(ctx context.Context, userId, fileId int64) (model.FileCost, error) {
+	var cost model.FileCost
+	err := d.db.QueryRowContext(ctx, `
+		SELECT c.tx_id, c.file_id, c.owner_id, COALESCE(f.wallet, ''), c.storage_fee, c.gas_used, c.gas_price, c.gas_fee, c.created_at
+		FROM file_costs c
+		LEFT JOIN files f ON f.id = c.file_id
+		WHERE c.file_id = ? AND c.owner_id = ?
+	`, fileId, userId).Scan(&cost.TxId, &cost.FileId, &cost.OwnerId, &cost.Wallet, &cost.StorageFee, &cost.GasUsed, &cost.GasPrice, &cost.GasFee, &cost.CreatedAt)
+	if err != nil {
+		return model.FileCost{}, err
+	}
+	cost.Total = sumWei(cost.StorageFee, cost.GasFee)
+	return cost, nil
+}
+
+// CostReport sums recorded costs by day and user, oldest day first. userId
+// limits the report to one user, 0 includes everyone. from and to are
+// inclusive YYYY-MM-DD days and may be empty.
+func (d *DBService) CostReport(ctx context.Context, userId int64, from, to string) ([]model.CostReportRow, error) {
+	query := `
+		SELECT CAST(date(c.created_at) AS TEXT), COALESCE(c.owner_id, 0), COALESCE(u.username, ''), c.storage_fee, c.gas_fee
+		FROM file_costs c
+		LEFT JOIN users u ON u.id = c.owner_id
+		WHERE 1 = 1`
+	var args []any
+	if userId != 0 {
+		query += ` AND c.owner_id = ?`
+		args = append(args, userId)
+	}
+	if from != "" {
+		query += ` AND date(c.created_at) >= ?`
+		args = append(args, from)
+	}
+	if to != "" {
+		query += ` AND date(c.created_at) <= ?`
+		args = append(args, to)
+	}
+	query += ` ORDER BY date(c.created_at), c.owner_id`
+
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// amounts are summed here since they can overflow SQLite integers
+	report := []model.CostReportRow{}
+	for rows.Next() {
+		var row model.CostReportRow
+		err := rows.Scan(&row.Day, &row.UserId, &row.Username, &row.StorageFee, &row.GasFee)
+		if err != nil {
+			return nil, err
+		}
+		n := len(report)
+		if n > 0 && report[n-1].Day == row.Day && report[n-1].UserId == row.UserId {
+			last := &report[n-1]
+			last.Files++
+			last.StorageFee = sumWei(last.StorageFee, row.StorageFee)
+			last.GasFee = sumWei(last.GasFee, row.GasFee)
+			last.Total = sumWei(last.StorageFee, last.GasFee)
+			continue
+		}
+		row.Files = 1
+		row.Total = sumWei(row.StorageFee, row.GasFee)
+		report = append(report, row)
+	}
+	return report, rows.Err()
+}
+
+// sumWei adds decimal wei amounts, treating unparsable ones as 0.
+func sumWei(amounts ...string) string {
+	total := new(big.Int)
+	for _, amount := range amounts {
+		n, ok := new(big.Int).SetString(amount, 10)
+		if ok {
+			total.Add(total, n)
+		}
+	}
+	return total.String()
+}
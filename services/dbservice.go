@@ -5,86 +5,203 @@ package services
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"log"
 	"time"
 	"zgdrive/model"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
+// DBService is the Store kept in SQLite or Postgres.
 type DBService struct {
-	db *sql.DB
+	db *dbConn
 }
 
-func NewDBService(dbname string) *DBService {
-	// create db if not exists
-	db, err := sql.Open("sqlite3", dbname)
+var _ Store = (*DBService)(nil)
+
+// NewDBService opens the database and applies pending migrations. It
+// refuses a database migrated by a newer build.
+func NewDBService(dsn string) *DBService {
+	d, err := OpenDBService(dsn)
 	if err != nil {
 		log.Printf("Error opening database: %v", err)
 		return nil
 	}
 
-	// create table if not exists
-	query := `
-		CREATE TABLE IF NOT EXISTS files (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			filename TEXT NOT NULL,
-			hash TEXT NOT NULL,
-			size INTEGER NOT NULL,
-			tx_id TEXT DEFAULT NULL,
-			is_uploaded BOOLEAN NOT NULL DEFAULT FALSE,
-			created_at TIMESTAMP DEFAULT (datetime('now','localtime'))
-		);
-		CREATE TABLE IF NOT EXISTS downloaded_files (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			file_id INTEGER NOT NULL,
-			filename TEXT NOT NULL,
-			hash TEXT NOT NULL,
-			size INTEGER NOT NULL,
-			downloaded_at TIMESTAMP DEFAULT (datetime('now','localtime')),
-			is_processing BOOLEAN NOT NULL DEFAULT TRUE,
-			is_removed BOOLEAN NOT NULL DEFAULT FALSE
-		);
-	`
-	_, err = db.Exec(query)
+	err = d.MigrateTo(context.Background(), LatestSchemaVersion())
 	if err != nil {
-		log.Printf("Error creating table: %v", err)
-		db.Close()
+		log.Printf("Error migrating database: %v", err)
+		d.Close()
 		return nil
 	}
 
-	return &DBService{db: db}
+	return d
+}
+
+// OpenDBService opens the database without migrating it. dsn is a
+// postgres:// URL or the path of a SQLite file, which is created if it
+// doesn't exist.
+func OpenDBService(dsn string) (*DBService, error) {
+	db, err := openDatabase(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMP DEFAULT (datetime('now','localtime'))
+		)
+	`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &DBService{db: db}, nil
+}
+
+func (d *DBService) Close() error {
+	return d.db.Close()
+}
+
+// fileColumns is the column list read by scanFile.
+const fileColumns = `id, COALESCE(owner_id, 0), filename, COALESCE(folder_id, 0), size, hash, tx_id, is_uploaded, wrapped_key,
+	COALESCE(logical_id, id), version, is_current, created_at, COALESCE(wallet, '')`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanFile(row rowScanner) (model.File, error) {
+	var file model.File
+	var txId sql.NullString
+	var wrappedKey sql.NullString
+	err := row.Scan(&file.ID, &file.OwnerId, &file.Filename, &file.FolderId, &file.Size, &file.Hash, &txId, &file.IsUploaded, &wrappedKey,
+		&file.LogicalId, &file.Version, &file.IsCurrent, &file.CreatedAt, &file.Wallet)
+	if err != nil {
+		return model.File{}, err
+	}
+
+	file.TxId = txId.String
+	file.Encrypted = wrappedKey.Valid
+	file.WrappedKey = wrappedKey.String
+	return file, nil
+}
+
+func scanFiles(rows *sql.Rows) ([]model.File, error) {
+	files := []model.File{}
+	for rows.Next() {
+		file, err := scanFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		file.SetSizeReadable()
+		files = append(files, file)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return files, nil
 }
 
-func (d *DBService) AddFile(ctx context.Context, filename, hash string, size int64) (model.File, error) {
+// AddFile records a new file owned by file.OwnerId. file.WrappedKey is the wrapped data key of an
+// encrypted file, or empty when the file is stored as plaintext. file.TxId
+// and file.IsUploaded are set when the file points at an object that is
+// already stored on 0G.
+//
+// When the folder already holds a file with the same name, the new file
+// becomes the next version of it and the current one. The file must fit in
+// the owner's quota, with defaults for the limits an admin has not set; the
+// check and the insert happen in one transaction, so concurrent uploads
+// can't overrun it together.
+func (d *DBService) AddFile(ctx context.Context, file model.File, defaults model.QuotaLimits) (model.File, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return model.File{}, err
+	}
+	defer tx.Rollback()
+
+	// writing the owner's row first holds off their other uploads until
+	// this one is committed
+	_, err = tx.ExecContext(ctx, `UPDATE users SET is_admin = is_admin WHERE id = ?`, file.OwnerId)
+	if err != nil {
+		return model.File{}, err
+	}
+	err = checkQuota(ctx, tx, file.OwnerId, file.Size, defaults)
+	if err != nil {
+		return model.File{}, err
+	}
+
+	var logicalId sql.NullInt64
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE(logical_id, id) FROM files
+		WHERE owner_id = ? AND COALESCE(folder_id, 0) = ? AND filename = ? AND is_current = TRUE
+		ORDER BY id DESC
+		LIMIT 1
+	`, file.OwnerId, file.FolderId, file.Filename).Scan(&logicalId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return model.File{}, err
+	}
+
+	file.Version = 1
+	if logicalId.Valid {
+		err = tx.QueryRowContext(ctx, `
+			SELECT MAX(version) + 1 FROM files WHERE COALESCE(logical_id, id) = ?
+		`, logicalId.Int64).Scan(&file.Version)
+		if err != nil {
+			return model.File{}, err
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE files SET is_current = FALSE WHERE COALESCE(logical_id, id) = ?
+		`, logicalId.Int64)
+		if err != nil {
+			return model.File{}, err
+		}
+	}
+
 	query := `
-		INSERT INTO files (filename, hash, size)
-		VALUES (?, ?, ?) RETURNING id, filename, size, is_uploaded, created_at
+		INSERT INTO files (owner_id, filename, hash, size, tx_id, is_uploaded, wrapped_key, folder_id, logical_id, version, is_current)
+		VALUES (?, ?, ?, ?, NULLIF(?, ''), ?, NULLIF(?, ''), NULLIF(?, 0), ?, ?, TRUE) RETURNING id, created_at
 	`
-	var id int64
-	var isUploaded bool
-	var createdAt time.Time
-	err := d.db.QueryRowContext(ctx, query, filename, hash, size).Scan(&id, &filename, &size, &isUploaded, &createdAt)
+	err = tx.QueryRowContext(ctx, query, file.OwnerId, file.Filename, file.Hash, file.Size, file.TxId, file.IsUploaded, file.WrappedKey, file.FolderId, logicalId, file.Version).Scan(&file.ID, &file.CreatedAt)
+	if err != nil {
+		return model.File{}, err
+	}
+
+	err = addObjectRef(ctx, tx, file.Hash)
 	if err != nil {
 		return model.File{}, err
 	}
 
-	return model.File{
-		ID:         id,
-		Filename:   filename,
-		Size:       size,
-		IsUploaded: isUploaded,
-		CreatedAt:  createdAt,
-	}, nil
+	if !logicalId.Valid {
+		// the first version names the logical file
+		_, err = tx.ExecContext(ctx, `UPDATE files SET logical_id = id WHERE id = ?`, file.ID)
+		if err != nil {
+			return model.File{}, err
+		}
+		logicalId.Int64 = file.ID
+	}
+
+	if err := tx.Commit(); err != nil {
+		return model.File{}, err
+	}
+
+	file.LogicalId = logicalId.Int64
+	file.IsCurrent = true
+	file.Encrypted = file.WrappedKey != ""
+	return file, nil
 }
 
-func (d *DBService) SetUploaded(ctx context.Context, filename string) error {
+func (d *DBService) SetUploaded(ctx context.Context, fileId int64) error {
 	query := `
 		UPDATE files
 		SET is_uploaded = TRUE
-		WHERE filename = ?
+		WHERE id = ?
 	`
-	_, err := d.db.ExecContext(ctx, query, filename)
+	_, err := d.db.ExecContext(ctx, query, fileId)
 	if err != nil {
 		return err
 	}
@@ -93,80 +210,97 @@ func (d *DBService) SetUploaded(ctx context.Context, filename string) error {
 
 func (d *DBService) GetFileById(ctx context.Context, fileId int64) (model.File, error) {
 	query := `
-		SELECT id, filename, size, hash, tx_id, is_uploaded, created_at
+		SELECT ` + fileColumns + `
 		FROM files
 		WHERE id = ?
 	`
-	row := d.db.QueryRowContext(ctx, query, fileId)
-	var id int64
-	var filename string
-	var size int64
-	var hash string
-	var txId sql.NullString
-	var isUploaded bool
-	var createdAt time.Time
-	err := row.Scan(&id, &filename, &size, &hash, &txId, &isUploaded, &createdAt)
-	if err != nil {
-		return model.File{}, err
-	}
+	return scanFile(d.db.QueryRowContext(ctx, query, fileId))
+}
 
-	return model.File{
-		ID:         id,
-		Filename:   filename,
-		Size:       size,
-		Hash:       hash,
-		TxId:       txId.String,
-		IsUploaded: isUploaded,
-		CreatedAt:  createdAt,
-	}, nil
+// GetUserFile returns a file owned by userId, or sql.ErrNoRows.
+func (d *DBService) GetUserFile(ctx context.Context, userId, fileId int64) (model.File, error) {
+	query := `
+		SELECT ` + fileColumns + `
+		FROM files
+		WHERE id = ? AND owner_id = ?
+	`
+	return scanFile(d.db.QueryRowContext(ctx, query, fileId, userId))
 }
 
-func (d *DBService) ListFiles(ctx context.Context) ([]model.File, error) {
+// ListFiles returns the current version of every file owned by userId.
+func (d *DBService) ListFiles(ctx context.Context, userId int64) ([]model.File, error) {
 	query := `
-		SELECT id, filename, size, hash, tx_id, is_uploaded, created_at
+		SELECT ` + fileColumns + `
 		FROM files
+		WHERE owner_id = ? AND is_current = TRUE
 		ORDER BY created_at DESC
 	`
-	rows, err := d.db.QueryContext(ctx, query)
+	rows, err := d.db.QueryContext(ctx, query, userId)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	files := []model.File{}
-	for rows.Next() {
-		var id int64
-		var filename string
-		var size int64
-		var hash string
-		var txId sql.NullString
-		var isUploaded bool
-		var createdAt time.Time
-		err := rows.Scan(&id, &filename, &size, &hash, &txId, &isUploaded, &createdAt)
-		if err != nil {
-			return nil, err
-		}
+	return scanFiles(rows)
+}
 
-		file := model.File{
-			ID:         id,
-			Filename:   filename,
-			Size:       size,
-			Hash:       hash,
-			IsUploaded: isUploaded,
-			CreatedAt:  createdAt,
-			TxId:       txId.String,
-		}
-		file.SetSizeReadable()
-		files = append(files, file)
+// ListVersions returns every version of the logical file fileId belongs to,
+// oldest first. The file must be owned by userId.
+func (d *DBService) ListVersions(ctx context.Context, userId, fileId int64) ([]model.File, error) {
+	query := `
+		SELECT ` + fileColumns + `
+		FROM files
+		WHERE COALESCE(logical_id, id) = (SELECT COALESCE(logical_id, id) FROM files WHERE id = ? AND owner_id = ?)
+		ORDER BY version
+	`
+	rows, err := d.db.QueryContext(ctx, query, fileId, userId)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	if err := rows.Err(); err != nil {
+	files, err := scanFiles(rows)
+	if err != nil {
 		return nil, err
 	}
-
+	if len(files) == 0 {
+		return nil, sql.ErrNoRows
+	}
 	return files, nil
 }
 
+// GetVersion returns a version of the logical file fileId belongs to. The
+// file must be owned by userId.
+func (d *DBService) GetVersion(ctx context.Context, userId, fileId int64, version int) (model.File, error) {
+	query := `
+		SELECT ` + fileColumns + `
+		FROM files
+		WHERE COALESCE(logical_id, id) = (SELECT COALESCE(logical_id, id) FROM files WHERE id = ? AND owner_id = ?) AND version = ?
+	`
+	return scanFile(d.db.QueryRowContext(ctx, query, fileId, userId, version))
+}
+
+// SetCurrentVersion makes the given version row the current one of its
+// logical file.
+func (d *DBService) SetCurrentVersion(ctx context.Context, versionId int64) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE files
+		SET is_current = (id = ?)
+		WHERE COALESCE(logical_id, id) = (SELECT COALESCE(logical_id, id) FROM files WHERE id = ?)
+	`, versionId, versionId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 func (d *DBService) UpdateTxId(ctx context.Context, fileId int64, txId string) error {
 	query := `
 		UPDATE files
@@ -183,7 +317,7 @@ func (d *DBService) UpdateTxId(ctx context.Context, fileId int64, txId string) e
 
 func (d *DBService) GetUnuploadedFiles(ctx context.Context) ([]model.File, error) {
 	query := `
-		SELECT id, filename, hash, size, tx_id
+		SELECT id, COALESCE(owner_id, 0), filename, hash, size, tx_id
 		FROM files
 		WHERE is_uploaded = FALSE AND tx_id IS NOT NULL
 	`
@@ -196,17 +330,19 @@ func (d *DBService) GetUnuploadedFiles(ctx context.Context) ([]model.File, error
 	files := []model.File{}
 	for rows.Next() {
 		var id int64
+		var ownerId int64
 		var filename string
 		var size int64
 		var txId string
 		var hash string
-		err := rows.Scan(&id, &filename, &hash, &size, &txId)
+		err := rows.Scan(&id, &ownerId, &filename, &hash, &size, &txId)
 		if err != nil {
 			return nil, err
 		}
 
 		file := model.File{
 			ID:       id,
+			OwnerId:  ownerId,
 			Filename: filename,
 			Size:     size,
 			TxId:     txId,
@@ -222,16 +358,32 @@ func (d *DBService) GetUnuploadedFiles(ctx context.Context) ([]model.File, error
 	return files, nil
 }
 
-func (d *DBService) NewDownloadedFile(ctx context.Context, file model.File) error {
+// NewDownloadedFile records a download of file that instance is going to
+// fetch.
+func (d *DBService) NewDownloadedFile(ctx context.Context, file model.File, instance string) (int64, error) {
+	query := `
+		INSERT INTO downloaded_files (owner_id, file_id, filename, hash, size, instance_id)
+		VALUES (?, ?, ?, ?, ?, ?) RETURNING id
+	`
+	var id int64
+	err := d.db.QueryRowContext(ctx, query, file.OwnerId, file.ID, file.Filename, file.Hash, file.Size, instance).Scan(&id)
+	return id, err
+}
+
+// ResetInterruptedDownloads drops the downloads instance was fetching when
+// it stopped, so they can be requested again. Downloads of other instances
+// sharing the database are left alone.
+func (d *DBService) ResetInterruptedDownloads(ctx context.Context, instance string) (int64, error) {
 	query := `
-		INSERT INTO downloaded_files (file_id, filename, hash, size)
-		VALUES (?, ?, ?, ?)
+		UPDATE downloaded_files
+		SET is_processing = FALSE, is_removed = TRUE
+		WHERE is_processing = TRUE AND is_removed = FALSE AND (instance_id = ? OR instance_id IS NULL)
 	`
-	_, err := d.db.ExecContext(ctx, query, file.ID, file.Filename, file.Hash, file.Size)
+	result, err := d.db.ExecContext(ctx, query, instance)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return nil
+	return result.RowsAffected()
 }
 
 func (d *DBService) GetExpiredDownloadedFiles(ctx context.Context, duration time.Duration) ([]model.File, error) {
@@ -274,26 +426,44 @@ func (d *DBService) GetExpiredDownloadedFiles(ctx context.Context, duration time
 	return files, nil
 }
 
-func (d *DBService) SetProcessing(ctx context.Context, fileId int64) error {
+// FinishDownloadedFile marks the download id as ready to be served.
+func (d *DBService) FinishDownloadedFile(ctx context.Context, id int64) error {
 	query := `
 		UPDATE downloaded_files
 		SET is_processing = FALSE
-		WHERE file_id = ?
+		WHERE id = ?
 	`
-	_, err := d.db.ExecContext(ctx, query, fileId)
+	_, err := d.db.ExecContext(ctx, query, id)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (d *DBService) ListDownloadedFiles(ctx context.Context) ([]model.DownloadedFile, error) {
+// FailDownloadedFile drops a download that could not be completed so it
+// can be requested again.
+func (d *DBService) FailDownloadedFile(ctx context.Context, id int64) error {
+	query := `
+		UPDATE downloaded_files
+		SET is_processing = FALSE, is_removed = TRUE
+		WHERE id = ?
+	`
+	_, err := d.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ListDownloadedFiles returns the downloads of files owned by userId that
+// are ready to be served.
+func (d *DBService) ListDownloadedFiles(ctx context.Context, userId int64) ([]model.DownloadedFile, error) {
 	query := `
 		SELECT id, file_id, filename, hash, size, is_processing, downloaded_at
 		FROM downloaded_files
-		WHERE is_removed = FALSE AND is_processing = FALSE
+		WHERE owner_id = ? AND is_removed = FALSE AND is_processing = FALSE
 	`
-	rows, err := d.db.QueryContext(ctx, query)
+	rows, err := d.db.QueryContext(ctx, query, userId)
 	if err != nil {
 		return nil, err
 	}
@@ -345,13 +515,30 @@ func (d *DBService) RemoveDownloadedFile(ctx context.Context, fileId int64) erro
 	return nil
 }
 
-func (d *DBService) CheckIsFileAlreadyDownloaded(ctx context.Context, hash string) (bool, error) {
+// IsCacheShared reports whether a download other than id that isn't removed
+// yet uses the cache entry of hash. The entry is shared by every download
+// of the same content, whoever requested it.
+func (d *DBService) IsCacheShared(ctx context.Context, id int64, hash string) (bool, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM downloaded_files
+		WHERE hash = ? AND id != ? AND is_removed = FALSE
+	`
+	var count int
+	err := d.db.QueryRowContext(ctx, query, hash, id).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (d *DBService) CheckIsFileAlreadyDownloaded(ctx context.Context, userId int64, hash string) (bool, error) {
 	query := `
 		SELECT COUNT(*)
 		FROM downloaded_files
-		WHERE hash = ? AND is_removed = FALSE
+		WHERE owner_id = ? AND hash = ? AND is_removed = FALSE
 	`
-	row := d.db.QueryRowContext(ctx, query, hash)
+	row := d.db.QueryRowContext(ctx, query, userId, hash)
 	var count int
 	err := row.Scan(&count)
 	if err != nil {
@@ -360,13 +547,13 @@ func (d *DBService) CheckIsFileAlreadyDownloaded(ctx context.Context, hash strin
 	return count > 0, nil
 }
 
-func (d *DBService) CheckDownloadStatus(ctx context.Context, hash string) (bool, error) {
+func (d *DBService) CheckDownloadStatus(ctx context.Context, userId int64, hash string) (bool, error) {
 	query := `
 		SELECT COUNT(*)
 		FROM downloaded_files
-		WHERE hash = ? AND is_removed = FALSE AND is_processing = FALSE
+		WHERE owner_id = ? AND hash = ? AND is_removed = FALSE AND is_processing = FALSE
 	`
-	row := d.db.QueryRowContext(ctx, query, hash)
+	row := d.db.QueryRowContext(ctx, query, userId, hash)
 	var count int
 	err := row.Scan(&count)
 	if err != nil {
@@ -389,3 +576,215 @@ func (d *DBService) GetHash(ctx context.Context, filename string) (string, error
 	}
 	return hash, nil
 }
+
+func (d *DBService) AddUploadJob(ctx context.Context, fileId int64) (model.UploadJob, error) {
+	query := `
+		INSERT INTO upload_jobs (file_id)
+		VALUES (?) RETURNING id, state, attempts, created_at, updated_at
+	`
+	job := model.UploadJob{FileId: fileId}
+	err := d.db.QueryRowContext(ctx, query, fileId).Scan(&job.ID, &job.State, &job.Attempts, &job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		return model.UploadJob{}, err
+	}
+	return job, nil
+}
+
+// ClaimNextUploadJob moves the oldest queued job to submitting on behalf of
+// instance and returns it.
+// Jobs for a file another version of which, or content the same owner
+// uploaded, is already being submitted by another worker are skipped, so
+// uploads of the same file stay in order.
+// It returns sql.ErrNoRows when nothing can be claimed, including when
+// another instance sharing the database claimed the job first.
+func (d *DBService) ClaimNextUploadJob(ctx context.Context, instance string) (model.UploadJob, error) {
+	query := `
+		UPDATE upload_jobs
+		SET state = ?, attempts = attempts + 1, instance_id = ?, updated_at = datetime('now','localtime')
+		WHERE id = (
+			SELECT j.id FROM upload_jobs j
+			JOIN files f ON f.id = j.file_id
+			WHERE j.state = ? AND NOT EXISTS (
+				SELECT 1 FROM upload_jobs bj
+				JOIN files bf ON bf.id = bj.file_id
+				WHERE bj.state = ? AND (
+					COALESCE(bf.logical_id, bf.id) = COALESCE(f.logical_id, f.id)
+					OR (bf.owner_id = f.owner_id AND bf.hash = f.hash)
+				)
+			)
+			ORDER BY j.id
+			LIMIT 1
+		) AND state = ?
+		RETURNING id, file_id, state, attempts
+	`
+	var job model.UploadJob
+	err := d.db.QueryRowContext(ctx, query, model.UploadJobSubmitting, instance, model.UploadJobQueued, model.UploadJobSubmitting, model.UploadJobQueued).Scan(&job.ID, &job.FileId, &job.State, &job.Attempts)
+	if err != nil {
+		return model.UploadJob{}, err
+	}
+	return job, nil
+}
+
+func (d *DBService) SetUploadJobState(ctx context.Context, jobId int64, state string, lastError string) error {
+	query := `
+		UPDATE upload_jobs
+		SET state = ?, last_error = NULLIF(?, ''), updated_at = datetime('now','localtime')
+		WHERE id = ?
+	`
+	_, err := d.db.ExecContext(ctx, query, state, lastError, jobId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// FailUploadJob records err on the job and queues it again, unless it has
+// already used maxAttempts, in which case it is marked failed for good.
+// It returns the state the job was moved to.
+func (d *DBService) FailUploadJob(ctx context.Context, job model.UploadJob, maxAttempts int, jobErr error) (string, error) {
+	state := model.UploadJobQueued
+	if job.Attempts >= maxAttempts {
+		state = model.UploadJobFailed
+	}
+	return state, d.SetUploadJobState(ctx, job.ID, state, jobErr.Error())
+}
+
+// RequeueUploadJob puts a claimed job back in the queue without counting
+// the attempt, for failures that say nothing about the file itself.
+func (d *DBService) RequeueUploadJob(ctx context.Context, job model.UploadJob, jobErr error) error {
+	_, err := d.db.ExecContext(ctx, `
+		UPDATE upload_jobs
+		SET state = ?, attempts = CASE WHEN attempts > 0 THEN attempts - 1 ELSE 0 END, last_error = ?, updated_at = datetime('now','localtime')
+		WHERE id = ?
+	`, model.UploadJobQueued, jobErr.Error(), job.ID)
+	return err
+}
+
+// SetUploadJobSubmitted records the tx hash and paying wallet on the file and
+// moves the job to submitted in one transaction, so a crash can't leave them out of sync.
+func (d *DBService) SetUploadJobSubmitted(ctx context.Context, job model.UploadJob, txId, wallet string) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE files
+		SET tx_id = ?, wallet = NULLIF(?, '')
+		WHERE id = ?
+	`, txId, wallet, job.FileId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE upload_jobs
+		SET state = ?, last_error = NULL, updated_at = datetime('now','localtime')
+		WHERE id = ?
+	`, model.UploadJobSubmitted, job.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (d *DBService) FinalizeUploadJobs(ctx context.Context, fileId int64) error {
+	query := `
+		UPDATE upload_jobs
+		SET state = ?, last_error = NULL, updated_at = datetime('now','localtime')
+		WHERE file_id = ? AND state = ?
+	`
+	_, err := d.db.ExecContext(ctx, query, model.UploadJobFinalized, fileId, model.UploadJobSubmitted)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// RecoverUploadJobs makes sure no upload is lost across restarts of
+// instance. The jobs it was submitting when it stopped are queued again;
+// jobs other instances are submitting are left alone. Files that never got
+// a tx_id nor a job (e.g. the process died right after AddFile) get one,
+// once they are old enough that the instance adding them can't be about to
+// queue the job itself.
+func (d *DBService) RecoverUploadJobs(ctx context.Context, instance string) (int64, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE upload_jobs
+		SET state = ?, updated_at = datetime('now','localtime')
+		WHERE state = ? AND (instance_id = ? OR instance_id IS NULL)
+			AND file_id IN (SELECT id FROM files WHERE tx_id IS NOT NULL)
+	`, model.UploadJobSubmitted, model.UploadJobSubmitting, instance)
+	if err != nil {
+		return 0, err
+	}
+
+	requeued, err := tx.ExecContext(ctx, `
+		UPDATE upload_jobs
+		SET state = ?, updated_at = datetime('now','localtime')
+		WHERE state = ? AND (instance_id = ? OR instance_id IS NULL)
+	`, model.UploadJobQueued, model.UploadJobSubmitting, instance)
+	if err != nil {
+		return 0, err
+	}
+
+	backfilled, err := tx.ExecContext(ctx, `
+		INSERT INTO upload_jobs (file_id)
+		SELECT id FROM files
+		WHERE is_uploaded = FALSE AND tx_id IS NULL AND created_at < ?
+			AND id NOT IN (SELECT file_id FROM upload_jobs)
+	`, time.Now().Add(-time.Minute))
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	n1, _ := requeued.RowsAffected()
+	n2, _ := backfilled.RowsAffected()
+	return n1 + n2, nil
+}
+
+// ListUploadJobs returns the upload jobs of files owned by userId, newest
+// first.
+func (d *DBService) ListUploadJobs(ctx context.Context, userId int64) ([]model.UploadJob, error) {
+	query := `
+		SELECT j.id, j.file_id, f.filename, j.state, j.attempts, j.last_error, j.created_at, j.updated_at
+		FROM upload_jobs j
+		JOIN files f ON f.id = j.file_id
+		WHERE f.owner_id = ?
+		ORDER BY j.id DESC
+	`
+	rows, err := d.db.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []model.UploadJob{}
+	for rows.Next() {
+		var job model.UploadJob
+		var lastError sql.NullString
+		err := rows.Scan(&job.ID, &job.FileId, &job.Filename, &job.State, &job.Attempts, &lastError, &job.CreatedAt, &job.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		job.LastError = lastError.String
+		jobs = append(jobs, job)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// dialect is what differs between the databases DBService can store its
+// metadata in. Queries are written for SQLite, in SQL Postgres accepts as
+// well, and rebind adapts them to the database.
+type dialect struct {
+	name   string
+	driver string
+	// rebind rewrites a query written for SQLite
+	rebind func(query string) string
+	// serialKey and timestamp are the column definitions migrations use
+	// for an auto-incrementing id and a creation time
+	serialKey string
+	timestamp string
+}
+
+var (
+	sqliteDialect = &dialect{
+		name:      "sqlite",
+		driver:    "sqlite3",
+		rebind:    func(query string) string { return query },
+		serialKey: "INTEGER PRIMARY KEY AUTOINCREMENT",
+		timestamp: "TIMESTAMP DEFAULT (datetime('now','localtime'))",
+	}
+	postgresDialect = &dialect{
+		name:      "postgres",
+		driver:    "pgx",
+		rebind:    cachedRebind(rebindPostgres),
+		serialKey: "BIGSERIAL PRIMARY KEY",
+		timestamp: "TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP",
+	}
+)
+
+// openDatabase opens the database named by dsn. A postgres:// or
+// postgresql:// URL is a Postgres database, anything else the path of a
+// SQLite file.
+func openDatabase(dsn string) (*dbConn, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		db, err := sql.Open(postgresDialect.driver, dsn)
+		if err != nil {
+			return nil, err
+		}
+		return &dbConn{DB: db, dialect: postgresDialect}, nil
+	}
+
+	// upload and download workers write concurrently, so wait on locks
+	// instead of failing with "database is locked"
+	db, err := sql.Open(sqliteDialect.driver, dsn+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, err
+	}
+	return &dbConn{DB: db, dialect: sqliteDialect}, nil
+}
+
+// sqliteNow and sqliteToday are how queries read the clock. SQLite stores
+// local times as text, Postgres compares instants.
+const (
+	sqliteNow   = `datetime('now','localtime')`
+	sqliteToday = `datetime('now', 'localtime', 'start of day')`
+)
+
+// rebindPostgres numbers the ? placeholders of a query and swaps SQLite's
+// clock functions for Postgres ones.
+func rebindPostgres(query string) string {
+	query = strings.ReplaceAll(query, sqliteToday, `date_trunc('day', CURRENT_TIMESTAMP)`)
+	query = strings.ReplaceAll(query, sqliteNow, `CURRENT_TIMESTAMP`)
+
+	var b strings.Builder
+	n := 0
+	inString := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inString = !inString
+		case r == '?' && !inString:
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// cachedRebind remembers the rewrites of rebind, queries are constants so
+// there are only so many of them.
+func cachedRebind(rebind func(string) string) func(string) string {
+	var cache sync.Map
+	return func(query string) string {
+		if rebound, ok := cache.Load(query); ok {
+			return rebound.(string)
+		}
+		rebound := rebind(query)
+		cache.Store(query, rebound)
+		return rebound
+	}
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "23505"
+	}
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+// dbConn is a database whose queries are rebound for its dialect.
+type dbConn struct {
+	*sql.DB
+	dialect *dialect
+}
+
+func (c *dbConn) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return c.DB.ExecContext(ctx, c.dialect.rebind(query), args...)
+}
+
+func (c *dbConn) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return c.DB.QueryContext(ctx, c.dialect.rebind(query), args...)
+}
+
+func (c *dbConn) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return c.DB.QueryRowContext(ctx, c.dialect.rebind(query), args...)
+}
+
+func (c *dbConn) Exec(query string, args ...any) (sql.Result, error) {
+	return c.ExecContext(context.Background(), query, args...)
+}
+
+func (c *dbConn) Query(query string, args ...any) (*sql.Rows, error) {
+	return c.QueryContext(context.Background(), query, args...)
+}
+
+func (c *dbConn) QueryRow(query string, args ...any) *sql.Row {
+	return c.QueryRowContext(context.Background(), query, args...)
+}
+
+func (c *dbConn) BeginTx(ctx context.Context, opts *sql.TxOptions) (*dbTx, error) {
+	tx, err := c.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &dbTx{Tx: tx, dialect: c.dialect}, nil
+}
+
+// dbTx is a transaction whose queries are rebound for its dialect.
+type dbTx struct {
+	*sql.Tx
+	dialect *dialect
+}
+
+func (t *dbTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return t.Tx.ExecContext(ctx, t.dialect.rebind(query), args...)
+}
+
+func (t *dbTx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return t.Tx.QueryContext(ctx, t.dialect.rebind(query), args...)
+}
+
+func (t *dbTx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return t.Tx.QueryRowContext(ctx, t.dialect.rebind(query), args...)
+}
+
+func (t *dbTx) Exec(query string, args ...any) (sql.Result, error) {
+	return t.ExecContext(context.Background(), query, args...)
+}
+
+func (t *dbTx) Query(query string, args ...any) (*sql.Rows, error) {
+	return t.QueryContext(context.Background(), query, args...)
+}
+
+func (t *dbTx) QueryRow(query string, args ...any) *sql.Row {
+	return t.QueryRowContext(context.Background(), query, args...)
+}
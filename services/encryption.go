@@ -0,0 +1,424 @@
+package services
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Files are encrypted with envelope encryption: every file gets a random
+// 256-bit data key, the data is sealed with AES-GCM in fixed-size chunks so
+// it can be streamed, and the data key is wrapped with the master key from
+// ENCRYPTION_MASTER_KEY and kept in the database.
+//
+// The ciphertext is a header followed by the sealed chunks:
+//
+//	magic (4) | chunk size (4) | nonce prefix (7)
+//
+// Chunk i is sealed with the nonce prefix || i (4 bytes) || last flag (1),
+// with the header as additional data, so chunks can't be reordered,
+// dropped or truncated without failing authentication.
+const (
+	encryptionMagic     = "ZGE1"
+	encryptionChunkSize = 64 * 1024
+	noncePrefixSize     = 7
+	encryptionHeaderLen = len(encryptionMagic) + 4 + noncePrefixSize
+	dataKeySize         = 32
+	gcmTagSize          = 16
+)
+
+var ErrDecrypt = errors.New("decryption failed: data is corrupted or the key is wrong")
+
+type Encryptor struct {
+	masterKey []byte
+}
+
+// NewEncryptorFromEnv returns nil when ENCRYPTION_MASTER_KEY is unset, in
+// which case files are stored as plaintext.
+func NewEncryptorFromEnv() (*Encryptor, error) {
+	v := os.Getenv("ENCRYPTION_MASTER_KEY")
+	if v == "" {
+		return nil, nil
+	}
+
+	masterKey, err := hex.DecodeString(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ENCRYPTION_MASTER_KEY: %w", err)
+	}
+	if len(masterKey) != 32 {
+		return nil, fmt.Errorf("invalid ENCRYPTION_MASTER_KEY: want 32 bytes, got %d", len(masterKey))
+	}
+
+	return &Encryptor{masterKey: masterKey}, nil
+}
+
+// EncryptFile encrypts src into dst with a new data key and returns the
+// wrapped data key.
+func (e *Encryptor) EncryptFile(src, dst string) (string, error) {
+	dataKey := make([]byte, dataKeySize)
+	_, err := rand.Read(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	wrappedKey, err := e.wrapKey(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return "", err
+	}
+
+	err = EncryptStream(out, in, dataKey)
+	if err != nil {
+		out.Close()
+		return "", err
+	}
+
+	return wrappedKey, out.Close()
+}
+
+// DecryptFile decrypts src into dst using the wrapped data key stored for
+// the file. dst is removed if decryption fails.
+func (e *Encryptor) DecryptFile(src, dst, wrappedKey string) error {
+	dataKey, err := e.UnwrapKey(wrappedKey)
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	err = DecryptStream(out, in, dataKey)
+	if err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+
+	return out.Close()
+}
+
+func (e *Encryptor) wrapKey(dataKey []byte) (string, error) {
+	aead, err := newGCM(e.masterKey)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	wrapped := aead.Seal(nonce, nonce, dataKey, []byte("zgdrive-data-key"))
+	return hex.EncodeToString(wrapped), nil
+}
+
+// UnwrapKey recovers a data key wrapped with the master key.
+func (e *Encryptor) UnwrapKey(wrappedKey string) ([]byte, error) {
+	wrapped, err := hex.DecodeString(wrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wrapped key: %w", err)
+	}
+
+	aead, err := newGCM(e.masterKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+
+	nonce, sealed := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, sealed, []byte("zgdrive-data-key"))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return dataKey, nil
+}
+
+// EncryptStream reads plaintext from r and writes the chunked ciphertext to w.
+func EncryptStream(w io.Writer, r io.Reader, dataKey []byte) error {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, encryptionHeaderLen)
+	copy(header, encryptionMagic)
+	binary.BigEndian.PutUint32(header[len(encryptionMagic):], encryptionChunkSize)
+	_, err = rand.Read(header[len(encryptionMagic)+4:])
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(header)
+	if err != nil {
+		return err
+	}
+	noncePrefix := header[len(encryptionMagic)+4:]
+
+	// read one chunk ahead so the last chunk can be flagged
+	buf := make([]byte, encryptionChunkSize)
+	next := make([]byte, encryptionChunkSize)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	sealed := make([]byte, 0, encryptionChunkSize+aead.Overhead())
+	for counter := uint32(0); ; counter++ {
+		last := n < encryptionChunkSize
+		var m int
+		if !last {
+			m, err = io.ReadFull(r, next)
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return err
+			}
+			last = m == 0
+		}
+
+		nonce := chunkNonce(noncePrefix, counter, last)
+		sealed = aead.Seal(sealed[:0], nonce, buf[:n], header)
+		_, err = w.Write(sealed)
+		if err != nil {
+			return err
+		}
+
+		if last {
+			return nil
+		}
+		buf, next = next, buf
+		n = m
+		if counter == ^uint32(0) {
+			return errors.New("file too large to encrypt")
+		}
+	}
+}
+
+// DecryptStream reads chunked ciphertext from r and writes the plaintext to w.
+// Nothing past a chunk that fails authentication is written.
+func DecryptStream(w io.Writer, r io.Reader, dataKey []byte) error {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, encryptionHeaderLen)
+	_, err = io.ReadFull(r, header)
+	if err != nil {
+		return ErrDecrypt
+	}
+	if !bytes.Equal(header[:len(encryptionMagic)], []byte(encryptionMagic)) {
+		return ErrDecrypt
+	}
+	chunkSize := int(binary.BigEndian.Uint32(header[len(encryptionMagic):]))
+	if chunkSize <= 0 || chunkSize > 16*1024*1024 {
+		return ErrDecrypt
+	}
+	noncePrefix := header[len(encryptionMagic)+4:]
+
+	sealedSize := chunkSize + aead.Overhead()
+	buf := make([]byte, sealedSize)
+	next := make([]byte, sealedSize)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	plain := make([]byte, 0, chunkSize)
+	for counter := uint32(0); ; counter++ {
+		last := n < sealedSize
+		var m int
+		if !last {
+			m, err = io.ReadFull(r, next)
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return err
+			}
+			last = m == 0
+		}
+
+		nonce := chunkNonce(noncePrefix, counter, last)
+		plain, err = aead.Open(plain[:0], nonce, buf[:n], header)
+		if err != nil {
+			return ErrDecrypt
+		}
+		_, err = w.Write(plain)
+		if err != nil {
+			return err
+		}
+
+		if last {
+			return nil
+		}
+		buf, next = next, buf
+		n = m
+	}
+}
+
+// EncryptedSize returns the size of the ciphertext EncryptStream produces for
+// plainSize bytes of plaintext.
+func EncryptedSize(plainSize int64) int64 {
+	chunks := (plainSize + encryptionChunkSize - 1) / encryptionChunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	return int64(encryptionHeaderLen) + plainSize + chunks*gcmTagSize
+}
+
+// DecryptReader reads the plaintext of chunked ciphertext at any offset,
+// decrypting only the chunks that cover the bytes being read.
+type DecryptReader struct {
+	r          io.ReaderAt
+	aead       cipher.AEAD
+	header     []byte
+	chunkSize  int64
+	sealedSize int64
+	chunks     int64
+	bodySize   int64
+	size       int64
+
+	mu    sync.Mutex
+	index int64
+	plain []byte
+}
+
+// NewDecryptReader returns a reader over the plaintext of the size bytes of
+// ciphertext in r, using the wrapped data key stored for the file.
+func (e *Encryptor) NewDecryptReader(r io.ReaderAt, size int64, wrappedKey string) (*DecryptReader, error) {
+	dataKey, err := e.UnwrapKey(wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, encryptionHeaderLen)
+	_, err = r.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if err != nil || !bytes.Equal(header[:len(encryptionMagic)], []byte(encryptionMagic)) {
+		return nil, ErrDecrypt
+	}
+	chunkSize := int64(binary.BigEndian.Uint32(header[len(encryptionMagic):]))
+	if chunkSize <= 0 || chunkSize > 16*1024*1024 {
+		return nil, ErrDecrypt
+	}
+
+	sealedSize := chunkSize + int64(aead.Overhead())
+	bodySize := size - int64(encryptionHeaderLen)
+	chunks := (bodySize + sealedSize - 1) / sealedSize
+	if chunks == 0 {
+		return nil, ErrDecrypt
+	}
+
+	return &DecryptReader{
+		r:          r,
+		aead:       aead,
+		header:     header,
+		chunkSize:  chunkSize,
+		sealedSize: sealedSize,
+		chunks:     chunks,
+		bodySize:   bodySize,
+		size:       bodySize - chunks*int64(aead.Overhead()),
+		index:      -1,
+	}, nil
+}
+
+// Size returns the size of the plaintext.
+func (d *DecryptReader) Size() int64 {
+	return d.size
+}
+
+func (d *DecryptReader) ReadAt(p []byte, off int64) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	n := 0
+	for n < len(p) {
+		if off >= d.size {
+			return n, io.EOF
+		}
+
+		index := off / d.chunkSize
+		if d.index != index {
+			err := d.loadChunk(index)
+			if err != nil {
+				return n, err
+			}
+		}
+
+		copied := copy(p[n:], d.plain[off-index*d.chunkSize:])
+		n += copied
+		off += int64(copied)
+	}
+	return n, nil
+}
+
+func (d *DecryptReader) loadChunk(index int64) error {
+	start := index * d.sealedSize
+	length := d.sealedSize
+	if start+length > d.bodySize {
+		length = d.bodySize - start
+	}
+
+	sealed := make([]byte, length)
+	_, err := d.r.ReadAt(sealed, int64(encryptionHeaderLen)+start)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	nonce := chunkNonce(d.header[len(encryptionMagic)+4:], uint32(index), index == d.chunks-1)
+	d.plain, err = d.aead.Open(d.plain[:0], nonce, sealed, d.header)
+	if err != nil {
+		d.index = -1
+		return ErrDecrypt
+	}
+	d.index = index
+	return nil
+}
+
+func chunkNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, noncePrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], counter)
+	if last {
+		nonce[noncePrefixSize+4] = 1
+	}
+	return nonce
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,22 @@
+package services
+
+import (
+	"os"
+	"strings"
+)
+
+// EnvList reads a comma separated setting from the environment, dropping
+// empty items and falling back to def when it is unset.
+func EnvList(name string, def []string) []string {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
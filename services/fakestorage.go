@@ -0,0 +1,315 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+	"zgdrive/model"
+
+	"github.com/0glabs/0g-storage-client/core"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// FakeStorage is an offline StorageBackend that stores objects in a local
+// directory keyed by Merkle root. An object only counts as finalized once
+// finalityDelay has passed since it was stored, to mimic 0G finality.
+// Submissions are priced at a fixed fake rate.
+type FakeStorage struct {
+	dir           string
+	finalityDelay time.Duration
+
+	costsMu sync.Mutex
+	costs   map[string]model.TxCost
+	// FAKE_WALLETS wallets, each starting with FAKE_WALLET_BALANCE
+	wallets  []common.Address
+	balances map[common.Address]*big.Int
+}
+
+// the first wallet FakeStorage pays from, the others follow it
+var fakeWallet = common.HexToAddress("0x000000000000000000000000000000000000fa4e")
+
+// prices charged by FakeStorage, in wei
+var (
+	fakePricePerSector = big.NewInt(30_000_000)
+	fakeGasPrice       = big.NewInt(1_000_000_000)
+)
+
+// fakeGasLimit approximates the gas of a submission with the given number
+// of nodes.
+func fakeGasLimit(nodes int) uint64 {
+	return 150_000 + 25_000*uint64(nodes)
+}
+
+func NewFakeStorage() (*FakeStorage, error) {
+	dir := os.Getenv("FAKE_STORAGE_DIR")
+	if dir == "" {
+		dir = "./fakestorage"
+	}
+
+	finalityDelay := 30 * time.Second
+	if v := os.Getenv("FAKE_FINALITY_DELAY"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FAKE_FINALITY_DELAY: %w", err)
+		}
+		finalityDelay = d
+	}
+
+	balance := new(big.Int).Mul(big.NewInt(100), big.NewInt(1e18))
+	if v := os.Getenv("FAKE_WALLET_BALANCE"); v != "" {
+		var ok bool
+		balance, ok = new(big.Int).SetString(v, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid FAKE_WALLET_BALANCE: %s", v)
+		}
+	}
+
+	walletCount := 1
+	if v := os.Getenv("FAKE_WALLETS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid FAKE_WALLETS: %s", v)
+		}
+		walletCount = n
+	}
+	var wallets []common.Address
+	balances := map[common.Address]*big.Int{}
+	for i := 0; i < walletCount; i++ {
+		wallet := common.BigToAddress(new(big.Int).Add(fakeWallet.Big(), big.NewInt(int64(i))))
+		wallets = append(wallets, wallet)
+		balances[wallet] = new(big.Int).Set(balance)
+	}
+
+	fmt.Println("fakeStorageDir:", dir)
+	fmt.Println("fakeFinalityDelay:", finalityDelay)
+
+	err := os.MkdirAll(filepath.Join(dir, "tx"), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FakeStorage{
+		dir:           dir,
+		finalityDelay: finalityDelay,
+		costs:         make(map[string]model.TxCost),
+		wallets:       wallets,
+		balances:      balances,
+	}, nil
+}
+
+func (f *FakeStorage) objectPath(rootHash string) string {
+	return filepath.Join(f.dir, rootHash)
+}
+
+// txPath is where the root hash submitted by a fake transaction is kept.
+func (f *FakeStorage) txPath(txId string) string {
+	return filepath.Join(f.dir, "tx", filepath.Base(txId))
+}
+
+func (f *FakeStorage) Wallets() []common.Address {
+	return f.wallets
+}
+
+func (f *FakeStorage) UploadFile(ctx context.Context, file string, wallet common.Address) (string, error) {
+	fmt.Println("Uploading file to fake storage:", file)
+	rootHash, err := FileHash(file)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return "", err
+	}
+	estimate, _ := f.EstimateCost(ctx, info.Size())
+	fee, _ := new(big.Int).SetString(estimate.StorageFee, 10)
+	total, _ := new(big.Int).SetString(estimate.Total, 10)
+
+	f.costsMu.Lock()
+	defer f.costsMu.Unlock()
+	balance, ok := f.balances[wallet]
+	if !ok {
+		return "", fmt.Errorf("unknown wallet %s", wallet.Hex())
+	}
+	if balance.Cmp(total) < 0 {
+		return "", fmt.Errorf("insufficient funds for gas * price + value: balance %s, tx cost %s", balance, total)
+	}
+
+	err = copyFile(file, f.objectPath(rootHash))
+	if err != nil {
+		return "", err
+	}
+	tx, err := fakeTxHash()
+	if err != nil {
+		return "", err
+	}
+	err = os.WriteFile(f.txPath(tx), []byte(rootHash), 0644)
+	if err != nil {
+		return "", err
+	}
+	balance.Sub(balance, total)
+	f.costs[tx] = newTxCost(tx, fee, estimate.GasLimit, fakeGasPrice)
+	return tx, nil
+}
+
+func (f *FakeStorage) EstimateCost(ctx context.Context, size int64) (model.CostEstimate, error) {
+	submission, sectors := estimateSubmission(size)
+	return newCostEstimate(size, sectors, fakePricePerSector, fakeGasLimit(len(submission.Nodes)), fakeGasPrice), nil
+}
+
+func (f *FakeStorage) Balance(ctx context.Context, wallet common.Address) (*big.Int, error) {
+	f.costsMu.Lock()
+	defer f.costsMu.Unlock()
+	balance, ok := f.balances[wallet]
+	if !ok {
+		return nil, fmt.Errorf("unknown wallet %s", wallet.Hex())
+	}
+	return new(big.Int).Set(balance), nil
+}
+
+// PendingTransactions is always 0, fake submissions are mined at once.
+func (f *FakeStorage) PendingTransactions(ctx context.Context, wallet common.Address) (uint64, error) {
+	return 0, nil
+}
+
+// TxCost only knows the transactions made since the process started.
+func (f *FakeStorage) TxCost(ctx context.Context, txId string) (model.TxCost, error) {
+	f.costsMu.Lock()
+	defer f.costsMu.Unlock()
+	cost, ok := f.costs[txId]
+	if !ok {
+		return model.TxCost{}, fmt.Errorf("unknown transaction %s", txId)
+	}
+	return cost, nil
+}
+
+func (f *FakeStorage) CheckFileStatus(ctx context.Context, rootHash string) (bool, error) {
+	info, err := os.Stat(f.objectPath(rootHash))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return time.Since(info.ModTime()) >= f.finalityDelay, nil
+}
+
+func (f *FakeStorage) ObjectInfo(ctx context.Context, rootHash string) (model.ObjectInfo, error) {
+	info, err := os.Stat(f.objectPath(rootHash))
+	if errors.Is(err, os.ErrNotExist) {
+		return model.ObjectInfo{}, ErrObjectNotFound
+	}
+	if err != nil {
+		return model.ObjectInfo{}, err
+	}
+
+	return model.ObjectInfo{
+		RootHash:  rootHash,
+		Size:      info.Size(),
+		Finalized: time.Since(info.ModTime()) >= f.finalityDelay,
+	}, nil
+}
+
+func (f *FakeStorage) SubmissionRoot(ctx context.Context, txId string) (string, error) {
+	rootHash, err := os.ReadFile(f.txPath(txId))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", ErrNotSubmission
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(rootHash), nil
+}
+
+func (f *FakeStorage) UploadedSegments(ctx context.Context, rootHash string) (uint64, error) {
+	info, err := os.Stat(f.objectPath(rootHash))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return NumSegments(info.Size()), nil
+}
+
+func (f *FakeStorage) DownloadFile(ctx context.Context, file string, hash string) (bool, error) {
+	isDone, err := f.CheckFileStatus(ctx, hash)
+	if err != nil {
+		return false, err
+	}
+	if !isDone {
+		return false, fmt.Errorf("file %s not found or not finalized", hash)
+	}
+
+	err = copyFile(f.objectPath(hash), file)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (f *FakeStorage) DownloadSegment(ctx context.Context, rootHash string, index uint64) ([]byte, error) {
+	isDone, err := f.CheckFileStatus(ctx, rootHash)
+	if err != nil {
+		return nil, err
+	}
+	if !isDone {
+		return nil, fmt.Errorf("file %s not found or not finalized", rootHash)
+	}
+
+	in, err := os.Open(f.objectPath(rootHash))
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	buf := make([]byte, core.DefaultSegmentSize)
+	n, err := in.ReadAt(buf, int64(index)*core.DefaultSegmentSize)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("segment %d of file %s is out of range", index, rootHash)
+	}
+	return buf[:n], nil
+}
+
+func fakeTxHash() (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return "0x" + hex.EncodeToString(buf), nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,298 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"zgdrive/model"
+)
+
+var (
+	ErrFolderExists   = errors.New("a folder with that name already exists here")
+	ErrFolderNotEmpty = errors.New("folder is not empty")
+	ErrFolderCycle    = errors.New("cannot move a folder into itself or one of its subfolders")
+	ErrInvalidName    = errors.New("name must not be empty or contain '/'")
+)
+
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
+}
+
+// CleanFilename returns the name a file sent under name is stored as, the
+// last element of the path a client may send, or ErrInvalidName when that
+// is no usable name.
+func CleanFilename(name string) (string, error) {
+	name = filepath.Base(name)
+	if !validName(name) {
+		return "", ErrInvalidName
+	}
+	return name, nil
+}
+
+// Folders belong to the user who created them. Every user has their own
+// root, folder 0, and only sees the folders and files they own.
+
+func (d *DBService) CreateFolder(ctx context.Context, userId int64, name string, parentId int64) (model.Folder, error) {
+	if !validName(name) {
+		return model.Folder{}, ErrInvalidName
+	}
+	if parentId != 0 {
+		_, err := d.GetFolder(ctx, userId, parentId)
+		if err != nil {
+			return model.Folder{}, err
+		}
+	}
+
+	query := `
+		INSERT INTO folders (owner_id, name, parent_id)
+		VALUES (?, ?, NULLIF(?, 0)) RETURNING id, created_at
+	`
+	folder := model.Folder{OwnerId: userId, Name: name, ParentId: parentId}
+	err := d.db.QueryRowContext(ctx, query, userId, name, parentId).Scan(&folder.ID, &folder.CreatedAt)
+	if isUniqueViolation(err) {
+		return model.Folder{}, ErrFolderExists
+	}
+	if err != nil {
+		return model.Folder{}, err
+	}
+	return folder, nil
+}
+
+// GetFolder returns a folder of userId by id. Id 0 is the root folder.
+func (d *DBService) GetFolder(ctx context.Context, userId, folderId int64) (model.Folder, error) {
+	if folderId == 0 {
+		return model.Folder{OwnerId: userId, Name: "/"}, nil
+	}
+
+	query := `
+		SELECT id, COALESCE(owner_id, 0), name, COALESCE(parent_id, 0), created_at
+		FROM folders
+		WHERE id = ? AND owner_id = ?
+	`
+	var folder model.Folder
+	err := d.db.QueryRowContext(ctx, query, folderId, userId).Scan(&folder.ID, &folder.OwnerId, &folder.Name, &folder.ParentId, &folder.CreatedAt)
+	if err != nil {
+		return model.Folder{}, err
+	}
+	return folder, nil
+}
+
+func (d *DBService) RenameFolder(ctx context.Context, userId, folderId int64, name string) error {
+	if !validName(name) {
+		return ErrInvalidName
+	}
+
+	query := `
+		UPDATE folders
+		SET name = ?
+		WHERE id = ? AND owner_id = ?
+	`
+	result, err := d.db.ExecContext(ctx, query, name, folderId, userId)
+	if isUniqueViolation(err) {
+		return ErrFolderExists
+	}
+	if err != nil {
+		return err
+	}
+	return expectOneRow(result)
+}
+
+// MoveFolder moves a folder under a new parent (0 for the root).
+func (d *DBService) MoveFolder(ctx context.Context, userId, folderId, parentId int64) error {
+	if parentId != 0 {
+		// the new parent must not be the folder itself or below it
+		query := `
+			WITH RECURSIVE ancestors(id) AS (
+				SELECT CAST(? AS BIGINT)
+				UNION
+				SELECT f.parent_id FROM folders f JOIN ancestors a ON f.id = a.id
+				WHERE f.parent_id IS NOT NULL
+			)
+			SELECT COUNT(*) FROM ancestors WHERE id = ?
+		`
+		var count int
+		err := d.db.QueryRowContext(ctx, query, parentId, folderId).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrFolderCycle
+		}
+		_, err = d.GetFolder(ctx, userId, parentId)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := `
+		UPDATE folders
+		SET parent_id = NULLIF(?, 0)
+		WHERE id = ? AND owner_id = ?
+	`
+	result, err := d.db.ExecContext(ctx, query, parentId, folderId, userId)
+	if isUniqueViolation(err) {
+		return ErrFolderExists
+	}
+	if err != nil {
+		return err
+	}
+	return expectOneRow(result)
+}
+
+// DeleteFolder removes an empty folder.
+func (d *DBService) DeleteFolder(ctx context.Context, userId, folderId int64) error {
+	_, err := d.GetFolder(ctx, userId, folderId)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		SELECT
+			(SELECT COUNT(*) FROM folders WHERE parent_id = ?) +
+			(SELECT COUNT(*) FROM files WHERE folder_id = ?)
+	`
+	var count int
+	err = d.db.QueryRowContext(ctx, query, folderId, folderId).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrFolderNotEmpty
+	}
+
+	result, err := d.db.ExecContext(ctx, `DELETE FROM folders WHERE id = ?`, folderId)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(result)
+}
+
+// ListFolder returns the subfolders and files directly inside a folder.
+func (d *DBService) ListFolder(ctx context.Context, userId, folderId int64) (model.FolderContents, error) {
+	folder, err := d.GetFolder(ctx, userId, folderId)
+	if err != nil {
+		return model.FolderContents{}, err
+	}
+
+	query := `
+		SELECT id, COALESCE(owner_id, 0), name, COALESCE(parent_id, 0), created_at
+		FROM folders
+		WHERE owner_id = ? AND COALESCE(parent_id, 0) = ?
+		ORDER BY name
+	`
+	rows, err := d.db.QueryContext(ctx, query, userId, folderId)
+	if err != nil {
+		return model.FolderContents{}, err
+	}
+	defer rows.Close()
+
+	folders := []model.Folder{}
+	for rows.Next() {
+		var f model.Folder
+		err := rows.Scan(&f.ID, &f.OwnerId, &f.Name, &f.ParentId, &f.CreatedAt)
+		if err != nil {
+			return model.FolderContents{}, err
+		}
+		folders = append(folders, f)
+	}
+	if err := rows.Err(); err != nil {
+		return model.FolderContents{}, err
+	}
+
+	fileRows, err := d.db.QueryContext(ctx, `
+		SELECT `+fileColumns+`
+		FROM files
+		WHERE owner_id = ? AND COALESCE(folder_id, 0) = ? AND is_current = TRUE
+		ORDER BY filename
+	`, userId, folderId)
+	if err != nil {
+		return model.FolderContents{}, err
+	}
+	defer fileRows.Close()
+
+	files, err := scanFiles(fileRows)
+	if err != nil {
+		return model.FolderContents{}, err
+	}
+
+	return model.FolderContents{Folder: folder, Folders: folders, Files: files}, nil
+}
+
+// MoveFile puts a file, with all its versions, in a folder (0 for the root).
+func (d *DBService) MoveFile(ctx context.Context, userId, fileId, folderId int64) error {
+	_, err := d.GetFolder(ctx, userId, folderId)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE files
+		SET folder_id = NULLIF(?, 0)
+		WHERE COALESCE(logical_id, id) = (SELECT COALESCE(logical_id, id) FROM files WHERE id = ? AND owner_id = ?)
+	`
+	result, err := d.db.ExecContext(ctx, query, folderId, fileId, userId)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(result)
+}
+
+// ResolvePath walks a path like /projects/2026/report.pdf from the root.
+// It returns the folder the path names, or the file when the last element
+// is a file; when several files share the name the newest one wins.
+func (d *DBService) ResolvePath(ctx context.Context, userId int64, path string) (*model.Folder, *model.File, error) {
+	var parts []string
+	for _, p := range strings.Split(path, "/") {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+
+	folder := model.Folder{OwnerId: userId, Name: "/"}
+	for i, name := range parts {
+		var next model.Folder
+		err := d.db.QueryRowContext(ctx, `
+			SELECT id, COALESCE(owner_id, 0), name, COALESCE(parent_id, 0), created_at
+			FROM folders
+			WHERE owner_id = ? AND COALESCE(parent_id, 0) = ? AND name = ?
+		`, userId, folder.ID, name).Scan(&next.ID, &next.OwnerId, &next.Name, &next.ParentId, &next.CreatedAt)
+		if err == nil {
+			folder = next
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) || i != len(parts)-1 {
+			return nil, nil, err
+		}
+
+		var fileId int64
+		err = d.db.QueryRowContext(ctx, `
+			SELECT id FROM files
+			WHERE owner_id = ? AND COALESCE(folder_id, 0) = ? AND filename = ? AND is_current = TRUE
+			ORDER BY created_at DESC, id DESC
+			LIMIT 1
+		`, userId, folder.ID, name).Scan(&fileId)
+		if err != nil {
+			return nil, nil, err
+		}
+		file, err := d.GetFileById(ctx, fileId)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, &file, nil
+	}
+
+	return &folder, nil, nil
+}
+
+func expectOneRow(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
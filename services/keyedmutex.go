@@ -0,0 +1,42 @@
+package services
+
+import "sync"
+
+// KeyedMutex serializes work per key while letting different keys run in
+// parallel. Entries are dropped once nobody holds or waits for them.
+type KeyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func NewKeyedMutex() *KeyedMutex {
+	return &KeyedMutex{locks: make(map[string]*keyedLock)}
+}
+
+// Lock locks key and returns the function that unlocks it.
+func (k *KeyedMutex) Lock(key string) func() {
+	k.mu.Lock()
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		k.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Layout decides where files live on local disk. Paths never contain the
+// user supplied filename, which is kept only as metadata:
+//
+//	<root>/staging/<file id>  uploads waiting to be submitted to 0G
+//	<root>/cache/<root hash>  downloaded files, ready to be served
+//	<root>/uploads/<session>  resumable uploads still receiving chunks
+//	<root>/tmp/<random>       files being received, encrypted or downloaded
+type Layout struct {
+	root string
+}
+
+func NewLayoutFromEnv() (*Layout, error) {
+	root := os.Getenv("DATA_DIR")
+	if root == "" {
+		root = "./data"
+	}
+	fmt.Println("dataDir:", root)
+
+	l := &Layout{root: root}
+	for _, dir := range []string{"staging", "cache", "uploads", "tmp"} {
+		err := os.MkdirAll(filepath.Join(root, dir), 0700)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// StagingPath is where an upload waits until it is finalized on 0G.
+func (l *Layout) StagingPath(fileId int64) string {
+	return filepath.Join(l.root, "staging", strconv.FormatInt(fileId, 10))
+}
+
+// CachePath is where the downloaded content of a root hash is kept.
+func (l *Layout) CachePath(rootHash string) string {
+	return filepath.Join(l.root, "cache", filepath.Base(rootHash))
+}
+
+// UploadPath is where the chunks of a resumable upload are assembled.
+func (l *Layout) UploadPath(sessionId string) string {
+	return filepath.Join(l.root, "uploads", filepath.Base(sessionId))
+}
+
+// TempPath returns a new unique path for work in progress.
+func (l *Layout) TempPath() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.root, "tmp", hex.EncodeToString(buf)), nil
+}
+
+// CleanTemp removes leftovers of work interrupted by a restart.
+func (l *Layout) CleanTemp() error {
+	dir := filepath.Join(l.root, "tmp")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		err := os.RemoveAll(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
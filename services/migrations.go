@@ -0,0 +1,549 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+	"zgdrive/model"
+)
+
+// A migration changes the schema from version-1 to version, or back. Each
+// runs in its own transaction together with its schema_migrations row.
+// Released migrations must never change, add a new one instead.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *dbTx) error
+	down    func(tx *dbTx) error
+}
+
+var migrations = []migration{
+	{1, "initial schema", initialSchemaUp, initialSchemaDown},
+	{2, "metadata backups", backupsUp, backupsDown},
+	{3, "share bytes served", shareBytesUp, shareBytesDown},
+	{4, "wallet quotas", walletQuotasUp, walletQuotasDown},
+	{5, "instance ids", instanceIdsUp, instanceIdsDown},
+}
+
+// LatestSchemaVersion is the schema version this build migrates to.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// SchemaVersion returns the version of the last applied migration, 0 for
+// a new database.
+func (d *DBService) SchemaVersion(ctx context.Context) (int, error) {
+	var version int
+	err := d.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
+
+// Migrations lists the migrations this build knows and when each was
+// applied.
+func (d *DBService) Migrations(ctx context.Context) ([]model.Migration, error) {
+	applied := map[int]time.Time{}
+	rows, err := d.db.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		err := rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := []model.Migration{}
+	for _, m := range migrations {
+		status := model.Migration{Version: m.version, Name: m.name}
+		if appliedAt, ok := applied[m.version]; ok {
+			status.AppliedAt = &appliedAt
+		}
+		result = append(result, status)
+	}
+	return result, nil
+}
+
+// MigrateTo applies or rolls back migrations until the schema is at
+// version. It refuses a database migrated by a newer build, whose schema
+// it doesn't know how to use or roll back.
+func (d *DBService) MigrateTo(ctx context.Context, version int) error {
+	if version < 0 || version > LatestSchemaVersion() {
+		return fmt.Errorf("unknown schema version %d, this build knows 0 to %d", version, LatestSchemaVersion())
+	}
+	current, err := d.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if current > LatestSchemaVersion() {
+		return fmt.Errorf("database schema version %d is newer than this build's %d, run a newer build", current, LatestSchemaVersion())
+	}
+
+	for _, m := range migrations {
+		if m.version <= current || m.version > version {
+			continue
+		}
+		err := d.applyMigration(ctx, m, m.up, `INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, m.version, m.name)
+		if err != nil {
+			return fmt.Errorf("migrating to %04d %s: %w", m.version, m.name, err)
+		}
+		log.Printf("Applied migration %04d %s", m.version, m.name)
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.version > current || m.version <= version {
+			continue
+		}
+		err := d.applyMigration(ctx, m, m.down, `DELETE FROM schema_migrations WHERE version = ?`, m.version)
+		if err != nil {
+			return fmt.Errorf("rolling back %04d %s: %w", m.version, m.name, err)
+		}
+		log.Printf("Rolled back migration %04d %s", m.version, m.name)
+	}
+	return nil
+}
+
+// applyMigration runs one direction of a migration and records it with
+// the given statement, all or nothing.
+func (d *DBService) applyMigration(ctx context.Context, m migration, step func(tx *dbTx) error, record string, args ...any) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = step(tx)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, record, args...)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// initialSchemaUp creates the tables as they were before migrations
+// existed. Databases created by those older builds are brought up to the
+// same schema, so they can be adopted as version 1.
+func initialSchemaUp(tx *dbTx) error {
+	if tx.dialect == postgresDialect {
+		return initialPostgresSchemaUp(tx)
+	}
+
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS files (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			filename TEXT NOT NULL,
+			hash TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			tx_id TEXT DEFAULT NULL,
+			is_uploaded BOOLEAN NOT NULL DEFAULT FALSE,
+			wrapped_key TEXT DEFAULT NULL,
+			folder_id INTEGER DEFAULT NULL REFERENCES folders(id),
+			logical_id INTEGER DEFAULT NULL,
+			version INTEGER NOT NULL DEFAULT 1,
+			is_current BOOLEAN NOT NULL DEFAULT TRUE,
+			owner_id INTEGER DEFAULT NULL REFERENCES users(id),
+			created_at TIMESTAMP DEFAULT (datetime('now','localtime'))
+		);
+		CREATE TABLE IF NOT EXISTS downloaded_files (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			file_id INTEGER NOT NULL,
+			filename TEXT NOT NULL,
+			hash TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			downloaded_at TIMESTAMP DEFAULT (datetime('now','localtime')),
+			is_processing BOOLEAN NOT NULL DEFAULT TRUE,
+			is_removed BOOLEAN NOT NULL DEFAULT FALSE,
+			owner_id INTEGER DEFAULT NULL REFERENCES users(id)
+		);
+		CREATE TABLE IF NOT EXISTS folders (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			parent_id INTEGER DEFAULT NULL REFERENCES folders(id),
+			owner_id INTEGER DEFAULT NULL REFERENCES users(id),
+			created_at TIMESTAMP DEFAULT (datetime('now','localtime'))
+		);
+		CREATE TABLE IF NOT EXISTS upload_jobs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			file_id INTEGER NOT NULL,
+			state TEXT NOT NULL DEFAULT 'queued',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT DEFAULT NULL,
+			created_at TIMESTAMP DEFAULT (datetime('now','localtime')),
+			updated_at TIMESTAMP DEFAULT (datetime('now','localtime'))
+		);
+		CREATE INDEX IF NOT EXISTS idx_upload_jobs_state ON upload_jobs (state);
+		CREATE TABLE IF NOT EXISTS upload_sessions (
+			id TEXT PRIMARY KEY,
+			filename TEXT NOT NULL,
+			folder_id INTEGER DEFAULT NULL REFERENCES folders(id),
+			size INTEGER NOT NULL,
+			upload_offset INTEGER NOT NULL DEFAULT 0,
+			owner_id INTEGER DEFAULT NULL REFERENCES users(id),
+			created_at TIMESTAMP DEFAULT (datetime('now','localtime')),
+			updated_at TIMESTAMP DEFAULT (datetime('now','localtime'))
+		);
+		CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL UNIQUE,
+			password_hash TEXT NOT NULL,
+			address TEXT DEFAULT NULL,
+			is_admin BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMP DEFAULT (datetime('now','localtime'))
+		);
+		CREATE TABLE IF NOT EXISTS sessions (
+			token_hash TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			expires_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP DEFAULT (datetime('now','localtime'))
+		);
+		CREATE TABLE IF NOT EXISTS siwe_nonces (
+			nonce TEXT PRIMARY KEY,
+			expires_at TIMESTAMP NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS shares (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			token TEXT NOT NULL UNIQUE,
+			file_id INTEGER NOT NULL REFERENCES files(id),
+			owner_id INTEGER NOT NULL REFERENCES users(id),
+			permission TEXT NOT NULL,
+			password_hash TEXT DEFAULT NULL,
+			expires_at TIMESTAMP DEFAULT NULL,
+			max_downloads INTEGER NOT NULL DEFAULT 0,
+			downloads INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP DEFAULT (datetime('now','localtime'))
+		);
+		CREATE TABLE IF NOT EXISTS user_quotas (
+			user_id INTEGER PRIMARY KEY REFERENCES users(id),
+			max_bytes INTEGER DEFAULT NULL,
+			max_files INTEGER DEFAULT NULL,
+			max_uploads_per_day INTEGER DEFAULT NULL,
+			updated_at TIMESTAMP DEFAULT (datetime('now','localtime'))
+		);
+		CREATE TABLE IF NOT EXISTS file_costs (
+			tx_id TEXT PRIMARY KEY,
+			file_id INTEGER NOT NULL,
+			owner_id INTEGER DEFAULT NULL,
+			storage_fee TEXT NOT NULL,
+			gas_used INTEGER NOT NULL,
+			gas_price TEXT NOT NULL,
+			gas_fee TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT (datetime('now','localtime'))
+		);
+		CREATE TABLE IF NOT EXISTS objects (
+			root_hash TEXT PRIMARY KEY,
+			ref_count INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP DEFAULT (datetime('now','localtime'))
+		);
+`)
+	if err != nil {
+		return err
+	}
+
+	// columns older builds added after the table was first created
+	for _, c := range []struct{ table, column, definition string }{
+		{"files", "wrapped_key", "TEXT DEFAULT NULL"},
+		{"files", "folder_id", "INTEGER DEFAULT NULL REFERENCES folders(id)"},
+		{"files", "logical_id", "INTEGER DEFAULT NULL"},
+		{"files", "version", "INTEGER NOT NULL DEFAULT 1"},
+		{"files", "is_current", "BOOLEAN NOT NULL DEFAULT TRUE"},
+		{"files", "owner_id", "INTEGER DEFAULT NULL REFERENCES users(id)"},
+		{"downloaded_files", "owner_id", "INTEGER DEFAULT NULL REFERENCES users(id)"},
+		{"folders", "owner_id", "INTEGER DEFAULT NULL REFERENCES users(id)"},
+		{"upload_sessions", "owner_id", "INTEGER DEFAULT NULL REFERENCES users(id)"},
+		{"users", "address", "TEXT DEFAULT NULL"},
+		{"users", "is_admin", "BOOLEAN NOT NULL DEFAULT FALSE"},
+		{"files", "wallet", "TEXT DEFAULT NULL"},
+	} {
+		err = ensureColumn(tx, c.table, c.column, c.definition)
+		if err != nil {
+			return err
+		}
+	}
+
+	// folder names are unique per owner, which needs the owner_id column,
+	// and a wallet belongs to one account. Accounts created before admins
+	// existed make their first user the admin.
+	_, err = tx.Exec(`
+		DROP INDEX IF EXISTS idx_folders_parent_name;
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_folders_owner_parent_name ON folders (IFNULL(owner_id, 0), IFNULL(parent_id, 0), name);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_users_address ON users (address);
+		UPDATE users SET is_admin = TRUE
+		WHERE id = (SELECT MIN(id) FROM users) AND NOT EXISTS (SELECT 1 FROM users WHERE is_admin);
+	
+	`)
+	if err != nil {
+		return err
+	}
+
+	return backfillObjects(tx)
+}
+
+// initialPostgresSchemaUp creates the same tables in Postgres. No older
+// build used Postgres, so there is nothing to bring up to date.
+func initialPostgresSchemaUp(tx *dbTx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS users (
+			id BIGSERIAL PRIMARY KEY,
+			username TEXT NOT NULL UNIQUE,
+			password_hash TEXT NOT NULL,
+			address TEXT DEFAULT NULL,
+			is_admin BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_users_address ON users (address);
+		CREATE TABLE IF NOT EXISTS folders (
+			id BIGSERIAL PRIMARY KEY,
+			name TEXT NOT NULL,
+			parent_id BIGINT DEFAULT NULL REFERENCES folders(id),
+			owner_id BIGINT DEFAULT NULL REFERENCES users(id),
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_folders_owner_parent_name ON folders (COALESCE(owner_id, 0), COALESCE(parent_id, 0), name);
+		CREATE TABLE IF NOT EXISTS files (
+			id BIGSERIAL PRIMARY KEY,
+			filename TEXT NOT NULL,
+			hash TEXT NOT NULL,
+			size BIGINT NOT NULL,
+			tx_id TEXT DEFAULT NULL,
+			is_uploaded BOOLEAN NOT NULL DEFAULT FALSE,
+			wrapped_key TEXT DEFAULT NULL,
+			folder_id BIGINT DEFAULT NULL REFERENCES folders(id),
+			logical_id BIGINT DEFAULT NULL,
+			version BIGINT NOT NULL DEFAULT 1,
+			is_current BOOLEAN NOT NULL DEFAULT TRUE,
+			owner_id BIGINT DEFAULT NULL REFERENCES users(id),
+			wallet TEXT DEFAULT NULL,
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS downloaded_files (
+			id BIGSERIAL PRIMARY KEY,
+			file_id BIGINT NOT NULL,
+			filename TEXT NOT NULL,
+			hash TEXT NOT NULL,
+			size BIGINT NOT NULL,
+			downloaded_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+			is_processing BOOLEAN NOT NULL DEFAULT TRUE,
+			is_removed BOOLEAN NOT NULL DEFAULT FALSE,
+			owner_id BIGINT DEFAULT NULL REFERENCES users(id)
+		);
+		CREATE TABLE IF NOT EXISTS upload_jobs (
+			id BIGSERIAL PRIMARY KEY,
+			file_id BIGINT NOT NULL,
+			state TEXT NOT NULL DEFAULT 'queued',
+			attempts BIGINT NOT NULL DEFAULT 0,
+			last_error TEXT DEFAULT NULL,
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_upload_jobs_state ON upload_jobs (state);
+		CREATE TABLE IF NOT EXISTS upload_sessions (
+			id TEXT PRIMARY KEY,
+			filename TEXT NOT NULL,
+			folder_id BIGINT DEFAULT NULL REFERENCES folders(id),
+			size BIGINT NOT NULL,
+			upload_offset BIGINT NOT NULL DEFAULT 0,
+			owner_id BIGINT DEFAULT NULL REFERENCES users(id),
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS sessions (
+			token_hash TEXT PRIMARY KEY,
+			user_id BIGINT NOT NULL REFERENCES users(id),
+			expires_at TIMESTAMPTZ NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS siwe_nonces (
+			nonce TEXT PRIMARY KEY,
+			expires_at TIMESTAMPTZ NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS shares (
+			id BIGSERIAL PRIMARY KEY,
+			token TEXT NOT NULL UNIQUE,
+			file_id BIGINT NOT NULL REFERENCES files(id),
+			owner_id BIGINT NOT NULL REFERENCES users(id),
+			permission TEXT NOT NULL,
+			password_hash TEXT DEFAULT NULL,
+			expires_at TIMESTAMPTZ DEFAULT NULL,
+			max_downloads BIGINT NOT NULL DEFAULT 0,
+			downloads BIGINT NOT NULL DEFAULT 0,
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS user_quotas (
+			user_id BIGINT PRIMARY KEY REFERENCES users(id),
+			max_bytes BIGINT DEFAULT NULL,
+			max_files BIGINT DEFAULT NULL,
+			max_uploads_per_day BIGINT DEFAULT NULL,
+			updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS file_costs (
+			tx_id TEXT PRIMARY KEY,
+			file_id BIGINT NOT NULL,
+			owner_id BIGINT DEFAULT NULL,
+			storage_fee TEXT NOT NULL,
+			gas_used BIGINT NOT NULL,
+			gas_price TEXT NOT NULL,
+			gas_fee TEXT NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS objects (
+			root_hash TEXT PRIMARY KEY,
+			ref_count BIGINT NOT NULL DEFAULT 0,
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+		);
+	`)
+	return err
+}
+
+func initialSchemaDown(tx *dbTx) error {
+	_, err := tx.Exec(`
+		DROP TABLE IF EXISTS objects;
+		DROP TABLE IF EXISTS file_costs;
+		DROP TABLE IF EXISTS user_quotas;
+		DROP TABLE IF EXISTS shares;
+		DROP TABLE IF EXISTS siwe_nonces;
+		DROP TABLE IF EXISTS sessions;
+		DROP TABLE IF EXISTS upload_sessions;
+		DROP TABLE IF EXISTS upload_jobs;
+		DROP TABLE IF EXISTS downloaded_files;
+		DROP TABLE IF EXISTS files;
+		DROP TABLE IF EXISTS folders;
+		DROP TABLE IF EXISTS users;
+	`)
+	return err
+}
+
+func backupsUp(tx *dbTx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE backups (
+			id ` + tx.dialect.serialKey + `,
+			root_hash TEXT NOT NULL,
+			tx_id TEXT NOT NULL,
+			wallet TEXT NOT NULL,
+			size BIGINT NOT NULL,
+			encrypted BOOLEAN NOT NULL,
+			schema_version INTEGER NOT NULL,
+			digest TEXT NOT NULL,
+			created_at ` + tx.dialect.timestamp + `
+		)
+	`)
+	return err
+}
+
+func backupsDown(tx *dbTx) error {
+	_, err := tx.Exec(`DROP TABLE backups`)
+	return err
+}
+
+// shareBytesUp counts share downloads in bytes served; the downloads
+// counted so far are taken as whole files.
+func shareBytesUp(tx *dbTx) error {
+	_, err := tx.Exec(`ALTER TABLE shares ADD COLUMN bytes_served BIGINT NOT NULL DEFAULT 0`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE shares
+		SET bytes_served = downloads * COALESCE((SELECT size FROM files WHERE files.id = shares.file_id), 0)
+	`)
+	return err
+}
+
+func shareBytesDown(tx *dbTx) error {
+	_, err := tx.Exec(`ALTER TABLE shares DROP COLUMN bytes_served`)
+	return err
+}
+
+// walletQuotasUp adds the daily limits of paying wallets and what each
+// wallet submitted per day.
+func walletQuotasUp(tx *dbTx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE wallet_quotas (
+			wallet TEXT PRIMARY KEY,
+			max_bytes_per_day BIGINT DEFAULT NULL,
+			max_submissions_per_day BIGINT DEFAULT NULL,
+			updated_at ` + tx.dialect.timestamp + `
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		CREATE TABLE wallet_usage (
+			wallet TEXT NOT NULL,
+			day TEXT NOT NULL,
+			bytes BIGINT NOT NULL DEFAULT 0,
+			submissions BIGINT NOT NULL DEFAULT 0,
+			PRIMARY KEY (wallet, day)
+		)
+	`)
+	return err
+}
+
+func walletQuotasDown(tx *dbTx) error {
+	_, err := tx.Exec(`DROP TABLE wallet_usage`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DROP TABLE wallet_quotas`)
+	return err
+}
+
+// instanceIdsUp records which instance claimed an upload job or fetches a
+// download, so an instance recovers only its own work after a restart.
+func instanceIdsUp(tx *dbTx) error {
+	_, err := tx.Exec(`ALTER TABLE upload_jobs ADD COLUMN instance_id TEXT DEFAULT NULL`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE downloaded_files ADD COLUMN instance_id TEXT DEFAULT NULL`)
+	return err
+}
+
+func instanceIdsDown(tx *dbTx) error {
+	_, err := tx.Exec(`ALTER TABLE downloaded_files DROP COLUMN instance_id`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE upload_jobs DROP COLUMN instance_id`)
+	return err
+}
+
+// ensureColumn adds a column to an existing table unless it is already there.
+func ensureColumn(tx *dbTx, table, column, definition string) error {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk)
+		if err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"zgdrive/model"
+)
+
+// Several files can point at the same storage object when their content
+// hashes to the same Merkle root. The objects table counts the files that
+// reference each root hash, so the locally kept copies of an object are
+// only removed once the last file using it is deleted.
+
+var ErrFileBusy = errors.New("file is being uploaded or downloaded, try again later")
+
+// backfillObjects counts references for files recorded before the objects
+// table existed.
+func backfillObjects(tx *dbTx) error {
+	_, err := tx.Exec(`
+		INSERT OR IGNORE INTO objects (root_hash, ref_count)
+		SELECT hash, COUNT(*) FROM files GROUP BY hash
+	`)
+	return err
+}
+
+func addObjectRef(ctx context.Context, tx *dbTx, rootHash string) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO objects (root_hash, ref_count) VALUES (?, 1)
+		ON CONFLICT (root_hash) DO UPDATE SET ref_count = objects.ref_count + 1
+	`, rootHash)
+	return err
+}
+
+// FindUploadedObject returns a file whose object with the given root hash
+// is already finalized on 0G, or sql.ErrNoRows.
+func (d *DBService) FindUploadedObject(ctx context.Context, rootHash string) (model.File, error) {
+	query := `
+		SELECT ` + fileColumns + `
+		FROM files
+		WHERE hash = ? AND is_uploaded = TRUE AND tx_id IS NOT NULL
+		ORDER BY id
+		LIMIT 1
+	`
+	return scanFile(d.db.QueryRowContext(ctx, query, rootHash))
+}
+
+// ObjectRefCount returns how many files reference a root hash.
+func (d *DBService) ObjectRefCount(ctx context.Context, rootHash string) (int64, error) {
+	var count int64
+	err := d.db.QueryRowContext(ctx, `SELECT ref_count FROM objects WHERE root_hash = ?`, rootHash).Scan(&count)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return count, err
+}
+
+// DeleteFile removes a file owned by userId with all its versions. It
+// returns the removed
+// versions and the root hashes no file references anymore, whose local
+// copies can be cleaned up. Files with an upload being submitted or a
+// download in progress can't be deleted.
+func (d *DBService) DeleteFile(ctx context.Context, userId, fileId int64) ([]model.File, []string, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT `+fileColumns+`
+		FROM files
+		WHERE COALESCE(logical_id, id) = (SELECT COALESCE(logical_id, id) FROM files WHERE id = ? AND owner_id = ?)
+	`, fileId, userId)
+	if err != nil {
+		return nil, nil, err
+	}
+	versions, err := scanFiles(rows)
+	rows.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(versions) == 0 {
+		return nil, nil, sql.ErrNoRows
+	}
+
+	var orphaned []string
+	for _, file := range versions {
+		var busy int
+		err = tx.QueryRowContext(ctx, `
+			SELECT
+				(SELECT COUNT(*) FROM upload_jobs WHERE file_id = ? AND state = ?) +
+				(SELECT COUNT(*) FROM downloaded_files WHERE file_id = ? AND is_processing = TRUE AND is_removed = FALSE)
+		`, file.ID, model.UploadJobSubmitting, file.ID).Scan(&busy)
+		if err != nil {
+			return nil, nil, err
+		}
+		if busy > 0 {
+			return nil, nil, ErrFileBusy
+		}
+
+		for _, query := range []string{
+			`DELETE FROM upload_jobs WHERE file_id = ?`,
+			`DELETE FROM shares WHERE file_id = ?`,
+			`UPDATE downloaded_files SET is_removed = TRUE WHERE file_id = ?`,
+			`DELETE FROM files WHERE id = ?`,
+		} {
+			_, err = tx.ExecContext(ctx, query, file.ID)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		var refs int64
+		err = tx.QueryRowContext(ctx, `
+			UPDATE objects SET ref_count = ref_count - 1
+			WHERE root_hash = ?
+			RETURNING ref_count
+		`, file.Hash).Scan(&refs)
+		if errors.Is(err, sql.ErrNoRows) {
+			// another version with the same content already released it
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if refs <= 0 {
+			_, err = tx.ExecContext(ctx, `DELETE FROM objects WHERE root_hash = ?`, file.Hash)
+			if err != nil {
+				return nil, nil, err
+			}
+			orphaned = append(orphaned, file.Hash)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return versions, orphaned, nil
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"zgdrive/model"
+
+	"github.com/0glabs/0g-storage-client/core"
+)
+
+// ProgressTracker keeps the latest progress of every active upload and
+// download and fans updates out to subscribers, such as the /events stream.
+type ProgressTracker struct {
+	mu          sync.Mutex
+	active      map[string]model.Progress
+	subscribers map[chan model.Progress]struct{}
+}
+
+func NewProgressTracker() *ProgressTracker {
+	return &ProgressTracker{
+		active:      make(map[string]model.Progress),
+		subscribers: make(map[chan model.Progress]struct{}),
+	}
+}
+
+func progressKey(kind string, fileId int64) string {
+	return fmt.Sprintf("%s:%d", kind, fileId)
+}
+
+// Update records p and broadcasts it. Transfers in a terminal phase are
+// broadcast once and then forgotten. Updates without a file id (e.g. while
+// an upload is still being hashed) are only broadcast.
+func (t *ProgressTracker) Update(p model.Progress) {
+	p.UpdatedAt = time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if p.FileId != 0 {
+		key := progressKey(p.Kind, p.FileId)
+		if p.Finished() {
+			delete(t.active, key)
+		} else {
+			t.active[key] = p
+		}
+	}
+
+	for ch := range t.subscribers {
+		select {
+		case ch <- p:
+		default:
+			// slow subscriber, drop the update rather than block workers
+		}
+	}
+}
+
+// Get returns the current progress of a transfer, if it is active.
+func (t *ProgressTracker) Get(kind string, fileId int64) (model.Progress, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.active[progressKey(kind, fileId)]
+	return p, ok
+}
+
+// Snapshot returns every active transfer, oldest update first.
+func (t *ProgressTracker) Snapshot() []model.Progress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	progress := make([]model.Progress, 0, len(t.active))
+	for _, p := range t.active {
+		progress = append(progress, p)
+	}
+	sort.Slice(progress, func(i, j int) bool {
+		return progress[i].UpdatedAt.Before(progress[j].UpdatedAt)
+	})
+	return progress
+}
+
+// Subscribe returns a channel receiving every update and a function that
+// must be called to unsubscribe.
+func (t *ProgressTracker) Subscribe() (<-chan model.Progress, func()) {
+	ch := make(chan model.Progress, 64)
+
+	t.mu.Lock()
+	t.subscribers[ch] = struct{}{}
+	t.mu.Unlock()
+
+	return ch, func() {
+		t.mu.Lock()
+		delete(t.subscribers, ch)
+		t.mu.Unlock()
+	}
+}
+
+// NumSegments returns how many 0G segments a file of the given size spans.
+func NumSegments(size int64) uint64 {
+	if size <= 0 {
+		return 0
+	}
+	return uint64((size + core.DefaultSegmentSize - 1) / core.DefaultSegmentSize)
+}
+
+// SegmentBytes converts a number of uploaded segments to bytes, capped at size.
+func SegmentBytes(segments uint64, size int64) int64 {
+	n := int64(segments) * core.DefaultSegmentSize
+	if n > size {
+		return size
+	}
+	return n
+}
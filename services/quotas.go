@@ -0,0 +1,227 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"zgdrive/model"
+)
+
+// Every version of every file counts towards a user's stored bytes, since
+// each one keeps its content on 0G. Paying wallets have daily limits on
+// what they submit, so no single wallet is drained however the users'
+// quotas are set. Limits an admin has not set fall back to the defaults
+// passed in by the caller.
+
+var (
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+	ErrUploadLimit   = errors.New("daily upload limit reached")
+	// ErrWalletLimit is returned when a wallet already submitted as much
+	// as it may today.
+	ErrWalletLimit = errors.New("wallet reached its daily limit")
+	// ErrWalletFileTooLarge is returned when a file is larger than a
+	// wallet may submit in a whole day.
+	ErrWalletFileTooLarge = errors.New("file is larger than the wallet may submit in a day")
+)
+
+// quotaQuery selects a model.Quota per user; the three parameters are the
+// default limits.
+const quotaQuery = `
+	SELECT u.id, u.username,
+		COALESCE(q.max_bytes, ?), COALESCE(q.max_files, ?), COALESCE(q.max_uploads_per_day, ?),
+		q.user_id IS NOT NULL,
+		(SELECT COALESCE(SUM(size), 0) FROM files WHERE owner_id = u.id),
+		(SELECT COUNT(*) FROM files WHERE owner_id = u.id AND is_current = TRUE),
+		(SELECT COUNT(*) FROM files WHERE owner_id = u.id AND created_at >= datetime('now', 'localtime', 'start of day'))
+	FROM users u
+	LEFT JOIN user_quotas q ON q.user_id = u.id
+`
+
+// queryRower is the database or a transaction, for quota checks that are
+// part of a larger change.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func scanQuota(row rowScanner) (model.Quota, error) {
+	var q model.Quota
+	err := row.Scan(&q.UserId, &q.Username, &q.MaxBytes, &q.MaxFiles, &q.MaxUploadsPerDay,
+		&q.Custom, &q.UsedBytes, &q.Files, &q.UploadsToday)
+	return q, err
+}
+
+// GetQuota returns the limits and usage of userId.
+func (d *DBService) GetQuota(ctx context.Context, userId int64, defaults model.QuotaLimits) (model.Quota, error) {
+	return getQuota(ctx, d.db, userId, defaults)
+}
+
+func getQuota(ctx context.Context, db queryRower, userId int64, defaults model.QuotaLimits) (model.Quota, error) {
+	return scanQuota(db.QueryRowContext(ctx, quotaQuery+`WHERE u.id = ?`,
+		defaults.MaxBytes, defaults.MaxFiles, defaults.MaxUploadsPerDay, userId))
+}
+
+// ListQuotas returns the limits and usage of every user.
+func (d *DBService) ListQuotas(ctx context.Context, defaults model.QuotaLimits) ([]model.Quota, error) {
+	rows, err := d.db.QueryContext(ctx, quotaQuery+`ORDER BY u.id`,
+		defaults.MaxBytes, defaults.MaxFiles, defaults.MaxUploadsPerDay)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	quotas := []model.Quota{}
+	for rows.Next() {
+		q, err := scanQuota(rows)
+		if err != nil {
+			return nil, err
+		}
+		quotas = append(quotas, q)
+	}
+	return quotas, rows.Err()
+}
+
+// SetQuota changes the limits of userId. A nil limit goes back to the
+// default.
+func (d *DBService) SetQuota(ctx context.Context, userId int64, maxBytes, maxFiles, maxUploadsPerDay *int64) error {
+	var exists bool
+	err := d.db.QueryRowContext(ctx, `SELECT TRUE FROM users WHERE id = ?`, userId).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if maxBytes == nil && maxFiles == nil && maxUploadsPerDay == nil {
+		_, err = d.db.ExecContext(ctx, `DELETE FROM user_quotas WHERE user_id = ?`, userId)
+		return err
+	}
+	_, err = d.db.ExecContext(ctx, `
+		INSERT INTO user_quotas (user_id, max_bytes, max_files, max_uploads_per_day)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET
+			max_bytes = excluded.max_bytes,
+			max_files = excluded.max_files,
+			max_uploads_per_day = excluded.max_uploads_per_day,
+			updated_at = datetime('now','localtime')
+	`, userId, maxBytes, maxFiles, maxUploadsPerDay)
+	return err
+}
+
+// CheckQuota reports whether userId may upload another file of size bytes.
+// It returns ErrQuotaExceeded when the file doesn't fit and ErrUploadLimit
+// when the user already uploaded as many files as allowed today. AddFile
+// checks again when the file is recorded.
+func (d *DBService) CheckQuota(ctx context.Context, userId, size int64, defaults model.QuotaLimits) error {
+	return checkQuota(ctx, d.db, userId, size, defaults)
+}
+
+func checkQuota(ctx context.Context, db queryRower, userId, size int64, defaults model.QuotaLimits) error {
+	q, err := getQuota(ctx, db, userId, defaults)
+	if err != nil {
+		return err
+	}
+	if q.MaxUploadsPerDay > 0 && q.UploadsToday >= q.MaxUploadsPerDay {
+		return fmt.Errorf("%w: %d uploads per day", ErrUploadLimit, q.MaxUploadsPerDay)
+	}
+	if q.MaxFiles > 0 && q.Files >= q.MaxFiles {
+		return fmt.Errorf("%w: limit of %d files reached", ErrQuotaExceeded, q.MaxFiles)
+	}
+	if q.MaxBytes > 0 && q.UsedBytes+size > q.MaxBytes {
+		return fmt.Errorf("%w: %d of %d bytes used, the file needs %d", ErrQuotaExceeded, q.UsedBytes, q.MaxBytes, size)
+	}
+	return nil
+}
+
+// walletQuotaQuery selects a model.WalletQuota; the parameters are the
+// default limits, the wallet and the day.
+const walletQuotaQuery = `
+	SELECT w.wallet,
+		COALESCE(q.max_bytes_per_day, ?), COALESCE(q.max_submissions_per_day, ?),
+		q.wallet IS NOT NULL, COALESCE(u.bytes, 0), COALESCE(u.submissions, 0)
+	FROM (SELECT CAST(? AS TEXT) AS wallet) w
+	LEFT JOIN wallet_quotas q ON q.wallet = w.wallet
+	LEFT JOIN wallet_usage u ON u.wallet = w.wallet AND u.day = ?
+`
+
+// today is the day wallet usage is counted under, in local time.
+func today() string {
+	return time.Now().Format(time.DateOnly)
+}
+
+func getWalletQuota(ctx context.Context, db queryRower, wallet, day string, defaults model.WalletLimits) (model.WalletQuota, error) {
+	var q model.WalletQuota
+	err := db.QueryRowContext(ctx, walletQuotaQuery, defaults.MaxBytesPerDay, defaults.MaxSubmissionsPerDay, wallet, day).Scan(
+		&q.Wallet, &q.MaxBytesPerDay, &q.MaxSubmissionsPerDay, &q.Custom, &q.BytesToday, &q.SubmissionsToday)
+	return q, err
+}
+
+// GetWalletQuota returns the limits of a paying wallet, given by its hex
+// address, and how much of them it used today.
+func (d *DBService) GetWalletQuota(ctx context.Context, wallet string, defaults model.WalletLimits) (model.WalletQuota, error) {
+	return getWalletQuota(ctx, d.db, wallet, today(), defaults)
+}
+
+// SetWalletQuota changes the limits of a paying wallet. A nil limit goes
+// back to the default.
+func (d *DBService) SetWalletQuota(ctx context.Context, wallet string, maxBytesPerDay, maxSubmissionsPerDay *int64) error {
+	if maxBytesPerDay == nil && maxSubmissionsPerDay == nil {
+		_, err := d.db.ExecContext(ctx, `DELETE FROM wallet_quotas WHERE wallet = ?`, wallet)
+		return err
+	}
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO wallet_quotas (wallet, max_bytes_per_day, max_submissions_per_day)
+		VALUES (?, ?, ?)
+		ON CONFLICT (wallet) DO UPDATE SET
+			max_bytes_per_day = excluded.max_bytes_per_day,
+			max_submissions_per_day = excluded.max_submissions_per_day,
+			updated_at = datetime('now','localtime')
+	`, wallet, maxBytesPerDay, maxSubmissionsPerDay)
+	return err
+}
+
+// ReserveWalletQuota counts a submission of size bytes against today's
+// limits of wallet before it pays for it. It returns ErrWalletLimit when
+// the submission doesn't fit in what is left today and
+// ErrWalletFileTooLarge when it wouldn't fit on any day. The check and the
+// count are one statement, so concurrent submissions can't overrun the
+// limits together.
+func (d *DBService) ReserveWalletQuota(ctx context.Context, wallet string, size int64, defaults model.WalletLimits) error {
+	day := today()
+	q, err := getWalletQuota(ctx, d.db, wallet, day, defaults)
+	if err != nil {
+		return err
+	}
+	if q.MaxBytesPerDay > 0 && size > q.MaxBytesPerDay {
+		return fmt.Errorf("%w: the file needs %d bytes, %s may submit %d a day", ErrWalletFileTooLarge, size, wallet, q.MaxBytesPerDay)
+	}
+
+	_, err = d.db.ExecContext(ctx, `
+		INSERT INTO wallet_usage (wallet, day) VALUES (?, ?)
+		ON CONFLICT (wallet, day) DO NOTHING
+	`, wallet, day)
+	if err != nil {
+		return err
+	}
+	query := `
+		UPDATE wallet_usage SET bytes = bytes + ?, submissions = submissions + 1
+		WHERE wallet = ? AND day = ?`
+	args := []any{size, wallet, day}
+	if q.MaxBytesPerDay > 0 {
+		query += ` AND bytes + ? <= ?`
+		args = append(args, size, q.MaxBytesPerDay)
+	}
+	if q.MaxSubmissionsPerDay > 0 {
+		query += ` AND submissions < ?`
+		args = append(args, q.MaxSubmissionsPerDay)
+	}
+	res, err := d.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	err = expectOneRow(res)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %s submitted %d of %d bytes and %d of %d files today",
+			ErrWalletLimit, wallet, q.BytesToday, q.MaxBytesPerDay, q.SubmissionsToday, q.MaxSubmissionsPerDay)
+	}
+	return err
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/0glabs/0g-storage-client/core"
+)
+
+// SegmentReader reads a stored object at any offset, fetching only the
+// segments that cover the bytes being read. The last fetched segment is
+// kept, so reading the object front to back fetches every segment once.
+type SegmentReader struct {
+	ctx      context.Context
+	storage  StorageBackend
+	rootHash string
+	size     int64
+
+	mu      sync.Mutex
+	index   uint64
+	segment []byte
+}
+
+// NewSegmentReader returns a reader over the object with the given root
+// hash and size. Segments are fetched with ctx, so cancelling it stops
+// reads in progress.
+func NewSegmentReader(ctx context.Context, storage StorageBackend, rootHash string, size int64) *SegmentReader {
+	return &SegmentReader{
+		ctx:      ctx,
+		storage:  storage,
+		rootHash: rootHash,
+		size:     size,
+	}
+}
+
+// Size returns the size of the object.
+func (r *SegmentReader) Size() int64 {
+	return r.size
+}
+
+func (r *SegmentReader) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for n < len(p) {
+		if off >= r.size {
+			return n, io.EOF
+		}
+
+		index := uint64(off / core.DefaultSegmentSize)
+		if r.segment == nil || r.index != index {
+			segment, err := r.storage.DownloadSegment(r.ctx, r.rootHash, index)
+			if err != nil {
+				return n, err
+			}
+			r.index, r.segment = index, segment
+		}
+
+		start := off - int64(index)*core.DefaultSegmentSize
+		if start >= int64(len(r.segment)) {
+			// the node returned less than the object size promised
+			return n, io.ErrUnexpectedEOF
+		}
+		copied := copy(p[n:], r.segment[start:])
+		n += copied
+		off += int64(copied)
+	}
+	return n, nil
+}
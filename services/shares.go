@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"time"
+	"zgdrive/model"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Shares are public links to a file version, identified by a random token.
+// The owner can limit a link by time, password and number of downloads.
+// Downloads are counted by the bytes served, so a download split into
+// ranges counts as much as one whole download. An empty file counts every
+// request.
+
+var (
+	ErrInvalidPermission = errors.New(`permission must be "view" or "download"`)
+	ErrShareExpired      = errors.New("share link has expired")
+	ErrShareExhausted    = errors.New("share link has reached its download limit")
+	ErrSharePassword     = errors.New("share link needs a valid password")
+)
+
+// shareColumns is the column list read by scanShare.
+const shareColumns = `s.id, s.token, s.file_id, f.filename, s.permission, s.password_hash IS NOT NULL,
+	s.expires_at, s.max_downloads, s.downloads, s.bytes_served, s.created_at`
+
+func scanShare(row rowScanner) (model.Share, error) {
+	var share model.Share
+	var expiresAt sql.NullTime
+	err := row.Scan(&share.ID, &share.Token, &share.FileId, &share.Filename, &share.Permission, &share.HasPassword,
+		&expiresAt, &share.MaxDownloads, &share.Downloads, &share.BytesServed, &share.CreatedAt)
+	if err != nil {
+		return model.Share{}, err
+	}
+	if expiresAt.Valid {
+		share.ExpiresAt = &expiresAt.Time
+	}
+	return share, nil
+}
+
+// CreateShare adds a link to a file userId owns. An empty password, a nil
+// expiresAt and a maxDownloads of 0 leave the link unrestricted.
+func (d *DBService) CreateShare(ctx context.Context, userId, fileId int64, permission, password string, expiresAt *time.Time, maxDownloads int64) (model.Share, error) {
+	if permission != model.SharePermissionView && permission != model.SharePermissionDownload {
+		return model.Share{}, ErrInvalidPermission
+	}
+	if maxDownloads < 0 {
+		maxDownloads = 0
+	}
+	file, err := d.GetUserFile(ctx, userId, fileId)
+	if err != nil {
+		return model.Share{}, err
+	}
+
+	var passwordHash sql.NullString
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return model.Share{}, err
+		}
+		passwordHash = sql.NullString{String: string(hash), Valid: true}
+	}
+	buf := make([]byte, 16)
+	_, err = rand.Read(buf)
+	if err != nil {
+		return model.Share{}, err
+	}
+
+	share := model.Share{
+		Token:        base64.RawURLEncoding.EncodeToString(buf),
+		FileId:       file.ID,
+		Filename:     file.Filename,
+		Permission:   permission,
+		HasPassword:  passwordHash.Valid,
+		ExpiresAt:    expiresAt,
+		MaxDownloads: maxDownloads,
+	}
+	err = d.db.QueryRowContext(ctx, `
+		INSERT INTO shares (token, file_id, owner_id, permission, password_hash, expires_at, max_downloads)
+		VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id, created_at
+	`, share.Token, file.ID, userId, permission, passwordHash, expiresAt, maxDownloads).Scan(&share.ID, &share.CreatedAt)
+	if err != nil {
+		return model.Share{}, err
+	}
+	return share, nil
+}
+
+// ListShares returns the links userId created, newest first.
+func (d *DBService) ListShares(ctx context.Context, userId int64) ([]model.Share, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT `+shareColumns+`
+		FROM shares s
+		JOIN files f ON f.id = s.file_id
+		WHERE s.owner_id = ?
+		ORDER BY s.id DESC
+	`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []model.Share{}
+	for rows.Next() {
+		share, err := scanShare(rows)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+// DeleteShare revokes a link userId created.
+func (d *DBService) DeleteShare(ctx context.Context, userId, shareId int64) error {
+	res, err := d.db.ExecContext(ctx, `DELETE FROM shares WHERE id = ? AND owner_id = ?`, shareId, userId)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(res)
+}
+
+// OpenShare returns the link with token and the file it points at, when
+// the link is still usable and password is right.
+func (d *DBService) OpenShare(ctx context.Context, token, password string) (model.Share, model.File, error) {
+	share, err := scanShare(d.db.QueryRowContext(ctx, `
+		SELECT `+shareColumns+`
+		FROM shares s
+		JOIN files f ON f.id = s.file_id
+		WHERE s.token = ?
+	`, token))
+	if err != nil {
+		return model.Share{}, model.File{}, err
+	}
+	if share.ExpiresAt != nil && !time.Now().Before(*share.ExpiresAt) {
+		return model.Share{}, model.File{}, ErrShareExpired
+	}
+	if share.HasPassword {
+		var passwordHash string
+		err = d.db.QueryRowContext(ctx, `SELECT password_hash FROM shares WHERE id = ?`, share.ID).Scan(&passwordHash)
+		if err != nil {
+			return model.Share{}, model.File{}, err
+		}
+		if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) != nil {
+			return model.Share{}, model.File{}, ErrSharePassword
+		}
+	}
+
+	file, err := d.GetFileById(ctx, share.FileId)
+	if err != nil {
+		return model.Share{}, model.File{}, err
+	}
+	if share.MaxDownloads > 0 && share.BytesServed >= share.MaxDownloads*max(file.Size, 1) {
+		return model.Share{}, model.File{}, ErrShareExhausted
+	}
+	return share, file, nil
+}
+
+// CountShareBytes records that a response through a link serves n bytes of
+// a file of size bytes, failing with ErrShareExhausted when they don't fit
+// in what is left of its limit.
+func (d *DBService) CountShareBytes(ctx context.Context, shareId, n, size int64) error {
+	if size == 0 {
+		n, size = 1, 1
+	}
+	res, err := d.db.ExecContext(ctx, `
+		UPDATE shares
+		SET bytes_served = bytes_served + ?, downloads = (bytes_served + ? + ? - 1) / ?
+		WHERE id = ? AND (max_downloads = 0 OR bytes_served + ? <= max_downloads * ?)
+	`, n, n, size, size, shareId, n, size)
+	if err != nil {
+		return err
+	}
+	err = expectOneRow(res)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrShareExhausted
+	}
+	return err
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/external"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/openweb3/web3go/interfaces"
+	"github.com/openweb3/web3go/signers"
+)
+
+// NewSignersFromEnv returns the signers of the wallets that pay for
+// submissions, picked by the SIGNER env var:
+//
+//   - "keystore" decrypts the geth JSON keystores in KEYSTORE_FILE with the
+//     passphrase in KEYSTORE_PASSWORD_FILE, or KEYSTORE_PASSWORD
+//   - "clef" asks the Clef compatible signer at CLEF_URL to sign, using the
+//     accounts in CLEF_ACCOUNT or the first account it lists
+//   - "env" reads hex private keys from PRIVATE_KEY, for development only
+//
+// Each variable takes a comma separated list to sign with several wallets.
+// When SIGNER is unset it is guessed from which of KEYSTORE_FILE, CLEF_URL
+// and PRIVATE_KEY is set. Keys and passphrases are never logged or put in
+// errors.
+func NewSignersFromEnv() ([]interfaces.Signer, error) {
+	kind := os.Getenv("SIGNER")
+	if kind == "" {
+		switch {
+		case os.Getenv("KEYSTORE_FILE") != "":
+			kind = "keystore"
+		case os.Getenv("CLEF_URL") != "":
+			kind = "clef"
+		default:
+			// a raw key is only meant for development, say so unless
+			// SIGNER=env asked for it
+			fmt.Println("Warning: signing with PRIVATE_KEY, use a keystore or Clef outside of development")
+			kind = "env"
+		}
+	}
+
+	switch kind {
+	case "keystore":
+		return newKeystoreSigners(EnvList("KEYSTORE_FILE", nil))
+	case "clef":
+		return newClefSigners(os.Getenv("CLEF_URL"), EnvList("CLEF_ACCOUNT", nil))
+	case "env":
+		privateKeys := EnvList("PRIVATE_KEY", nil)
+		os.Unsetenv("PRIVATE_KEY")
+		if len(privateKeys) == 0 {
+			return nil, fmt.Errorf("PRIVATE_KEY is not set")
+		}
+		var result []interfaces.Signer
+		for i, privateKey := range privateKeys {
+			signer, err := signers.NewPrivateKeySignerByString(privateKey)
+			if err != nil {
+				// the error of a malformed key can quote it
+				return nil, fmt.Errorf("invalid PRIVATE_KEY %d", i+1)
+			}
+			result = append(result, signer)
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("unknown signer: %s", kind)
+	}
+}
+
+func newKeystoreSigners(paths []string) ([]interfaces.Signer, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("KEYSTORE_FILE is not set")
+	}
+
+	// a passphrase in a file stays out of the process environment
+	passphrase := os.Getenv("KEYSTORE_PASSWORD")
+	os.Unsetenv("KEYSTORE_PASSWORD")
+	if passwordFile := os.Getenv("KEYSTORE_PASSWORD_FILE"); passwordFile != "" {
+		data, err := os.ReadFile(passwordFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading KEYSTORE_PASSWORD_FILE: %w", err)
+		}
+		passphrase = strings.TrimRight(string(data), "\r\n")
+	}
+
+	var result []interfaces.Signer
+	for _, path := range paths {
+		signer, err := signers.NewPrivateKeySignerByKeystoreFile(path, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("opening keystore %s: %w", path, err)
+		}
+		result = append(result, signer)
+	}
+	return result, nil
+}
+
+// clefSigner signs with an account of an external signer speaking Clef's
+// JSON-RPC API, so the key never enters this process.
+type clefSigner struct {
+	clef    *external.ExternalSigner
+	account accounts.Account
+}
+
+func newClefSigners(url string, addresses []string) ([]interfaces.Signer, error) {
+	if url == "" {
+		return nil, fmt.Errorf("CLEF_URL is not set")
+	}
+	clef, err := external.NewExternalSigner(url)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to Clef: %w", err)
+	}
+
+	// Clef asks its operator before listing accounts, an empty list means
+	// the request was denied
+	listed := clef.Accounts()
+	if len(listed) == 0 {
+		return nil, fmt.Errorf("the Clef signer listed no accounts")
+	}
+	if len(addresses) == 0 {
+		return []interfaces.Signer{&clefSigner{clef: clef, account: listed[0]}}, nil
+	}
+	var result []interfaces.Signer
+	for _, address := range addresses {
+		if !common.IsHexAddress(address) {
+			return nil, fmt.Errorf("invalid CLEF_ACCOUNT: %s", address)
+		}
+		want := accounts.Account{Address: common.HexToAddress(address)}
+		if !clef.Contains(want) {
+			return nil, fmt.Errorf("the Clef signer has no account %s", want.Address.Hex())
+		}
+		result = append(result, &clefSigner{clef: clef, account: want})
+	}
+	return result, nil
+}
+
+func (s *clefSigner) Address() common.Address {
+	return s.account.Address
+}
+
+func (s *clefSigner) SignTransaction(tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	return s.clef.SignTx(s.account, tx, chainID)
+}
+
+func (s *clefSigner) SignMessage(text []byte) ([]byte, error) {
+	return s.clef.SignText(s.account, text)
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// clefStub answers the account_ methods of Clef's external API with keys
+// it holds, approving every request.
+type clefStub struct {
+	keys []*ecdsa.PrivateKey
+}
+
+type clefSignResult struct {
+	Raw hexutil.Bytes      `json:"raw"`
+	Tx  *types.Transaction `json:"tx"`
+}
+
+func (s *clefStub) Version() string {
+	return "6.0.0"
+}
+
+func (s *clefStub) List() []common.Address {
+	addresses := []common.Address{}
+	for _, key := range s.keys {
+		addresses = append(addresses, crypto.PubkeyToAddress(key.PublicKey))
+	}
+	return addresses
+}
+
+func (s *clefStub) SignTransaction(args apitypes.SendTxArgs) (*clefSignResult, error) {
+	for _, key := range s.keys {
+		if crypto.PubkeyToAddress(key.PublicKey) != args.From.Address() {
+			continue
+		}
+		tx, err := args.ToTransaction()
+		if err != nil {
+			return nil, err
+		}
+		signed, err := types.SignTx(tx, types.LatestSignerForChainID((*big.Int)(args.ChainID)), key)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := signed.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		return &clefSignResult{Raw: raw, Tx: signed}, nil
+	}
+	return nil, rpc.ErrNoResult
+}
+
+// startClef serves a clefStub holding n new keys over HTTP.
+func startClef(t *testing.T, n int) (string, []common.Address) {
+	t.Helper()
+	stub := &clefStub{}
+	for i := 0; i < n; i++ {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stub.keys = append(stub.keys, key)
+	}
+
+	server := rpc.NewServer()
+	err := server.RegisterName("account", stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(func() {
+		httpServer.Close()
+		server.Stop()
+	})
+	return httpServer.URL, stub.List()
+}
+
+func TestClefSignersListAccounts(t *testing.T) {
+	url, addresses := startClef(t, 2)
+
+	signers, err := newClefSigners(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signers) != 1 || signers[0].Address() != addresses[0] {
+		t.Fatalf("without CLEF_ACCOUNT got %d signers, want the first listed account %s", len(signers), addresses[0])
+	}
+
+	signers, err = newClefSigners(url, []string{addresses[1].Hex(), strings.ToLower(addresses[0].Hex())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signers) != 2 || signers[0].Address() != addresses[1] || signers[1].Address() != addresses[0] {
+		t.Fatalf("got %d signers, want the two named accounts in order", len(signers))
+	}
+}
+
+func TestClefSignersRejectUnknownAccount(t *testing.T) {
+	url, _ := startClef(t, 1)
+
+	other := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	_, err := newClefSigners(url, []string{other.Hex()})
+	if err == nil || !strings.Contains(err.Error(), "has no account") {
+		t.Fatalf("got %v, want an error naming the missing account", err)
+	}
+
+	_, err = newClefSigners(url, []string{"not-an-address"})
+	if err == nil || !strings.Contains(err.Error(), "invalid CLEF_ACCOUNT") {
+		t.Fatalf("got %v, want an invalid CLEF_ACCOUNT error", err)
+	}
+}
+
+func TestClefSignersNoAccounts(t *testing.T) {
+	url, _ := startClef(t, 0)
+
+	_, err := newClefSigners(url, nil)
+	if err == nil || !strings.Contains(err.Error(), "listed no accounts") {
+		t.Fatalf("got %v, want an error about the empty listing", err)
+	}
+}
+
+func TestClefSignerSignTransaction(t *testing.T) {
+	url, addresses := startClef(t, 1)
+	signers, err := newClefSigners(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chainID := big.NewInt(16600)
+	to := common.HexToAddress("0x0460aA47b41a66694c0a73f667a1b795A5ED3556")
+	for _, tx := range []*types.Transaction{
+		types.NewTx(&types.LegacyTx{Nonce: 7, GasPrice: big.NewInt(1e9), Gas: 21000, To: &to, Value: big.NewInt(1), Data: []byte{1, 2}}),
+		types.NewTx(&types.DynamicFeeTx{ChainID: chainID, Nonce: 8, GasTipCap: big.NewInt(1e9), GasFeeCap: big.NewInt(2e9), Gas: 21000, To: &to, Value: big.NewInt(1)}),
+	} {
+		signed, err := signers[0].SignTransaction(tx, chainID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		from, err := types.Sender(types.LatestSignerForChainID(chainID), signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if from != addresses[0] {
+			t.Errorf("type %d transaction signed by %s, want %s", tx.Type(), from, addresses[0])
+		}
+		if signed.Nonce() != tx.Nonce() || *signed.To() != to || signed.Gas() != tx.Gas() {
+			t.Errorf("type %d transaction changed while signing", tx.Type())
+		}
+	}
+}
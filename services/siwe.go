@@ -0,0 +1,209 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Sign-In with Ethereum (EIP-4361): the client fetches a nonce, has the
+// wallet sign a message that contains it, and sends the message and the
+// signature back. The nonce can only be used once, and the account is the
+// address the signature recovers to.
+
+var (
+	ErrInvalidSiweMessage = errors.New("invalid sign-in message")
+	ErrInvalidSignature   = errors.New("signature does not match the sign-in message")
+	ErrInvalidNonce       = errors.New("nonce is unknown, expired or already used")
+)
+
+const siwePreamble = " wants you to sign in with your Ethereum account:"
+
+// SiweMessage is a parsed EIP-4361 message.
+type SiweMessage struct {
+	Domain         string
+	Address        common.Address
+	Statement      string
+	URI            string
+	Version        string
+	ChainId        int64
+	Nonce          string
+	IssuedAt       time.Time
+	ExpirationTime time.Time
+	NotBefore      time.Time
+	RequestId      string
+	Resources      []string
+}
+
+// ParseSiweMessage parses the text of an EIP-4361 message.
+func ParseSiweMessage(text string) (SiweMessage, error) {
+	var msg SiweMessage
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if len(lines) < 4 {
+		return msg, fmt.Errorf("%w: too short", ErrInvalidSiweMessage)
+	}
+
+	domain, ok := strings.CutSuffix(lines[0], siwePreamble)
+	if !ok || domain == "" {
+		return msg, fmt.Errorf("%w: missing preamble", ErrInvalidSiweMessage)
+	}
+	msg.Domain = domain
+	if !common.IsHexAddress(lines[1]) {
+		return msg, fmt.Errorf("%w: invalid address", ErrInvalidSiweMessage)
+	}
+	msg.Address = common.HexToAddress(lines[1])
+	if lines[2] != "" {
+		return msg, fmt.Errorf("%w: expected an empty line after the address", ErrInvalidSiweMessage)
+	}
+
+	// the statement is optional and followed by an empty line
+	i := 3
+	if lines[i] == "" {
+		i++
+	} else if !strings.HasPrefix(lines[i], "URI: ") {
+		msg.Statement = lines[i]
+		if i+1 >= len(lines) || lines[i+1] != "" {
+			return msg, fmt.Errorf("%w: expected an empty line after the statement", ErrInvalidSiweMessage)
+		}
+		i += 2
+	}
+
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if line == "Resources:" {
+			for i++; i < len(lines); i++ {
+				resource, ok := strings.CutPrefix(lines[i], "- ")
+				if !ok {
+					return msg, fmt.Errorf("%w: invalid resource %q", ErrInvalidSiweMessage, lines[i])
+				}
+				msg.Resources = append(msg.Resources, resource)
+			}
+			break
+		}
+
+		key, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			return msg, fmt.Errorf("%w: invalid line %q", ErrInvalidSiweMessage, line)
+		}
+		var err error
+		switch key {
+		case "URI":
+			msg.URI = value
+		case "Version":
+			msg.Version = value
+		case "Chain ID":
+			msg.ChainId, err = strconv.ParseInt(value, 10, 64)
+		case "Nonce":
+			msg.Nonce = value
+		case "Issued At":
+			msg.IssuedAt, err = time.Parse(time.RFC3339, value)
+		case "Expiration Time":
+			msg.ExpirationTime, err = time.Parse(time.RFC3339, value)
+		case "Not Before":
+			msg.NotBefore, err = time.Parse(time.RFC3339, value)
+		case "Request ID":
+			msg.RequestId = value
+		default:
+			return msg, fmt.Errorf("%w: unknown field %q", ErrInvalidSiweMessage, key)
+		}
+		if err != nil {
+			return msg, fmt.Errorf("%w: invalid %s: %v", ErrInvalidSiweMessage, key, err)
+		}
+	}
+
+	switch {
+	case msg.URI == "":
+		return msg, fmt.Errorf("%w: missing URI", ErrInvalidSiweMessage)
+	case msg.Version != "1":
+		return msg, fmt.Errorf("%w: unsupported version %q", ErrInvalidSiweMessage, msg.Version)
+	case msg.ChainId == 0:
+		return msg, fmt.Errorf("%w: missing chain ID", ErrInvalidSiweMessage)
+	case len(msg.Nonce) < 8:
+		return msg, fmt.Errorf("%w: nonce is too short", ErrInvalidSiweMessage)
+	case msg.IssuedAt.IsZero():
+		return msg, fmt.Errorf("%w: missing issued at", ErrInvalidSiweMessage)
+	}
+	return msg, nil
+}
+
+// CheckTime reports whether the message is valid at now.
+func (m SiweMessage) CheckTime(now time.Time) error {
+	if !m.ExpirationTime.IsZero() && !now.Before(m.ExpirationTime) {
+		return fmt.Errorf("%w: message has expired", ErrInvalidSiweMessage)
+	}
+	if !m.NotBefore.IsZero() && now.Before(m.NotBefore) {
+		return fmt.Errorf("%w: message is not valid yet", ErrInvalidSiweMessage)
+	}
+	return nil
+}
+
+// RecoverSiweSigner returns the address that produced signature, a hex
+// encoded personal_sign signature of text.
+func RecoverSiweSigner(text, signature string) (common.Address, error) {
+	sig, err := hexutil.Decode(signature)
+	if err != nil || len(sig) != crypto.SignatureLength {
+		return common.Address{}, ErrInvalidSignature
+	}
+	// wallets return v as 27 or 28, crypto expects 0 or 1
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+
+	pub, err := crypto.SigToPub(accounts.TextHash([]byte(text)), sig)
+	if err != nil {
+		return common.Address{}, ErrInvalidSignature
+	}
+	return crypto.PubkeyToAddress(*pub), nil
+}
+
+// CreateSiweNonce stores a new sign-in nonce that is valid for ttl.
+// Expired nonces are dropped on the way.
+func (d *DBService) CreateSiweNonce(ctx context.Context, ttl time.Duration) (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	nonce := hex.EncodeToString(buf)
+
+	_, err = d.db.ExecContext(ctx, `DELETE FROM siwe_nonces WHERE expires_at < ?`, time.Now())
+	if err != nil {
+		return "", err
+	}
+	_, err = d.db.ExecContext(ctx, `
+		INSERT INTO siwe_nonces (nonce, expires_at)
+		VALUES (?, ?)
+	`, nonce, time.Now().Add(ttl))
+	if err != nil {
+		return "", err
+	}
+	return nonce, nil
+}
+
+// ConsumeSiweNonce uses up a nonce. It returns ErrInvalidNonce when the
+// nonce was never issued, has expired or was already used.
+func (d *DBService) ConsumeSiweNonce(ctx context.Context, nonce string) error {
+	res, err := d.db.ExecContext(ctx, `
+		DELETE FROM siwe_nonces
+		WHERE nonce = ? AND expires_at > ?
+	`, nonce, time.Now())
+	if err != nil {
+		return err
+	}
+	err = expectOneRow(res)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidNonce
+	}
+	return err
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"zgdrive/model"
+)
+
+// A snapshot file is a header line followed by the snapshot as gzipped
+// JSON:
+//
+//	zgdrive-snapshot <format> <wrapped data key, or - when not encrypted>
+//
+// The JSON is sealed with a data key like an encrypted file, so a snapshot
+// can be restored from its root hash and the master key alone. Snapshots
+// taken by older builds may be unencrypted and are still read.
+const (
+	snapshotMagic = "zgdrive-snapshot"
+	// SnapshotFormat is the version of the snapshot layout this build
+	// writes and the newest it reads.
+	SnapshotFormat = 1
+)
+
+var (
+	ErrInvalidSnapshot = errors.New("not a zgDrive snapshot")
+	// ErrSnapshotUnencrypted is returned when a snapshot would go to 0G in
+	// the clear, where anyone could read its password hashes and share
+	// tokens.
+	ErrSnapshotUnencrypted = errors.New("snapshots hold password hashes and share tokens, set ENCRYPTION_MASTER_KEY to back them up")
+)
+
+// SnapshotDigest returns the SHA-256 of a snapshot's contents. Snapshots of
+// the same metadata have the same digest, whether they are encrypted or not.
+func SnapshotDigest(snapshot model.Snapshot) (string, error) {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// WriteSnapshot writes a snapshot file to w, encrypted with a data key
+// wrapped by encryptor.
+func WriteSnapshot(w io.Writer, snapshot model.Snapshot, encryptor *Encryptor) error {
+	if encryptor == nil {
+		return ErrSnapshotUnencrypted
+	}
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	err := json.NewEncoder(zw).Encode(snapshot)
+	if err != nil {
+		return err
+	}
+	err = zw.Close()
+	if err != nil {
+		return err
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	_, err = rand.Read(dataKey)
+	if err != nil {
+		return err
+	}
+	wrappedKey, err := encryptor.wrapKey(dataKey)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s %d %s\n", snapshotMagic, SnapshotFormat, wrappedKey)
+	if err != nil {
+		return err
+	}
+	return EncryptStream(w, &compressed, dataKey)
+}
+
+// ReadSnapshot reads a snapshot file written by WriteSnapshot. encryptor
+// may be nil for an unencrypted snapshot taken by an older build.
+func ReadSnapshot(r io.Reader, encryptor *Encryptor) (model.Snapshot, error) {
+	br := bufio.NewReader(r)
+	header, err := br.ReadString('\n')
+	if err != nil {
+		return model.Snapshot{}, ErrInvalidSnapshot
+	}
+	var format int
+	var wrappedKey string
+	_, err = fmt.Sscanf(strings.TrimSuffix(header, "\n"), snapshotMagic+" %d %s", &format, &wrappedKey)
+	if err != nil {
+		return model.Snapshot{}, ErrInvalidSnapshot
+	}
+	if format > SnapshotFormat {
+		return model.Snapshot{}, ErrSnapshotTooNew
+	}
+
+	var body io.Reader = br
+	if wrappedKey != "-" {
+		if encryptor == nil {
+			return model.Snapshot{}, fmt.Errorf("snapshot is encrypted, set ENCRYPTION_MASTER_KEY to the key it was taken with")
+		}
+		dataKey, err := encryptor.UnwrapKey(wrappedKey)
+		if err != nil {
+			return model.Snapshot{}, err
+		}
+		var plain bytes.Buffer
+		err = DecryptStream(&plain, br, dataKey)
+		if err != nil {
+			return model.Snapshot{}, err
+		}
+		body = &plain
+	}
+
+	zr, err := gzip.NewReader(body)
+	if err != nil {
+		return model.Snapshot{}, ErrInvalidSnapshot
+	}
+	defer zr.Close()
+
+	var snapshot model.Snapshot
+	dec := json.NewDecoder(zr)
+	// keep ids and sizes exact, RestoreSnapshot turns them into integers
+	dec.UseNumber()
+	err = dec.Decode(&snapshot)
+	if err != nil {
+		return model.Snapshot{}, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	return snapshot, nil
+}
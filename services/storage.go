@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"zgdrive/model"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// StorageBackend is the set of storage operations zgDrive needs from 0G.
+// ZgService talks to a live indexer and EVM RPC, FakeStorage keeps
+// everything on the local filesystem.
+type StorageBackend interface {
+	// Wallets returns the wallets that can pay for submissions.
+	Wallets() []common.Address
+	// UploadFile submits a file paid for by wallet and returns the
+	// transaction hash.
+	UploadFile(ctx context.Context, file string, wallet common.Address) (string, error)
+	DownloadFile(ctx context.Context, file string, hash string) (bool, error)
+	CheckFileStatus(ctx context.Context, rootHash string) (bool, error)
+	// UploadedSegments returns how many segments of the file storage nodes
+	// have received so far.
+	UploadedSegments(ctx context.Context, rootHash string) (uint64, error)
+	// DownloadSegment returns the data of one segment of a stored object,
+	// verified against the object's Merkle root. The last segment is
+	// trimmed to the object size.
+	DownloadSegment(ctx context.Context, rootHash string, index uint64) ([]byte, error)
+	// EstimateCost returns the projected storage fee and gas of submitting
+	// size bytes.
+	EstimateCost(ctx context.Context, size int64) (model.CostEstimate, error)
+	// TxCost returns what a transaction returned by UploadFile cost.
+	TxCost(ctx context.Context, txId string) (model.TxCost, error)
+	// ObjectInfo returns the size and finality of a stored object, or
+	// ErrObjectNotFound when no storage node knows it.
+	ObjectInfo(ctx context.Context, rootHash string) (model.ObjectInfo, error)
+	// SubmissionRoot returns the root hash of the object a transaction
+	// submitted to the flow contract, or ErrNotSubmission.
+	SubmissionRoot(ctx context.Context, txId string) (string, error)
+	// Balance returns the balance of a wallet in wei.
+	Balance(ctx context.Context, wallet common.Address) (*big.Int, error)
+	// PendingTransactions returns how many transactions sent from a wallet
+	// are waiting to be mined.
+	PendingTransactions(ctx context.Context, wallet common.Address) (uint64, error)
+}
+
+var (
+	ErrObjectNotFound = errors.New("object not found on 0G")
+	ErrNotSubmission  = errors.New("transaction did not submit a file to 0G")
+)
+
+var (
+	_ StorageBackend = (*ZgService)(nil)
+	_ StorageBackend = (*FakeStorage)(nil)
+)
+
+// NewStorageBackend picks the backend from the STORAGE_BACKEND env var.
+// It defaults to 0G when the variable is unset.
+func NewStorageBackend() (StorageBackend, error) {
+	backend := os.Getenv("STORAGE_BACKEND")
+	fmt.Println("storageBackend:", backend)
+
+	switch backend {
+	case "", "zg":
+		return NewZgService()
+	case "fake":
+		return NewFakeStorage()
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", backend)
+	}
+}
+
+// Downloader fetches stored objects, which is all restoring a snapshot
+// needs.
+type Downloader interface {
+	DownloadFile(ctx context.Context, file string, hash string) (bool, error)
+}
+
+// NewDownloaderFromEnv returns the backend STORAGE_BACKEND names, set up
+// only to download. Unlike NewStorageBackend it needs no wallets, so 0G is
+// reached through IND_RPC alone.
+func NewDownloaderFromEnv() (Downloader, error) {
+	backend := os.Getenv("STORAGE_BACKEND")
+	fmt.Println("storageBackend:", backend)
+
+	switch backend {
+	case "", "zg":
+		return NewZgDownloader()
+	case "fake":
+		return NewFakeStorage()
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", backend)
+	}
+}
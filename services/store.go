@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"time"
+	"zgdrive/model"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Store keeps zgDrive's metadata: users, files and their versions, folders,
+// jobs, share links, quotas and costs. The files themselves are on 0G.
+// DBService implements it on SQLite, for a single instance, and on
+// Postgres, which several instances behind a load balancer can share.
+type Store interface {
+	// files and versions
+	AddFile(ctx context.Context, file model.File, defaults model.QuotaLimits) (model.File, error)
+	GetFileById(ctx context.Context, fileId int64) (model.File, error)
+	GetUserFile(ctx context.Context, userId, fileId int64) (model.File, error)
+	ListFiles(ctx context.Context, userId int64) ([]model.File, error)
+	ListVersions(ctx context.Context, userId, fileId int64) ([]model.File, error)
+	GetVersion(ctx context.Context, userId, fileId int64, version int) (model.File, error)
+	SetCurrentVersion(ctx context.Context, versionId int64) error
+	MoveFile(ctx context.Context, userId, fileId, folderId int64) error
+	DeleteFile(ctx context.Context, userId, fileId int64) ([]model.File, []string, error)
+	GetHash(ctx context.Context, filename string) (string, error)
+	UpdateTxId(ctx context.Context, fileId int64, txId string) error
+	SetUploaded(ctx context.Context, fileId int64) error
+	GetUnuploadedFiles(ctx context.Context) ([]model.File, error)
+	FindUploadedObject(ctx context.Context, rootHash string) (model.File, error)
+	ObjectRefCount(ctx context.Context, rootHash string) (int64, error)
+
+	// folders
+	CreateFolder(ctx context.Context, userId int64, name string, parentId int64) (model.Folder, error)
+	GetFolder(ctx context.Context, userId, folderId int64) (model.Folder, error)
+	RenameFolder(ctx context.Context, userId, folderId int64, name string) error
+	MoveFolder(ctx context.Context, userId, folderId, parentId int64) error
+	DeleteFolder(ctx context.Context, userId, folderId int64) error
+	ListFolder(ctx context.Context, userId, folderId int64) (model.FolderContents, error)
+	ResolvePath(ctx context.Context, userId int64, path string) (*model.Folder, *model.File, error)
+
+	// upload jobs and resumable uploads
+	AddUploadJob(ctx context.Context, fileId int64) (model.UploadJob, error)
+	ClaimNextUploadJob(ctx context.Context, instance string) (model.UploadJob, error)
+	SetUploadJobState(ctx context.Context, jobId int64, state string, lastError string) error
+	FailUploadJob(ctx context.Context, job model.UploadJob, maxAttempts int, jobErr error) (string, error)
+	RequeueUploadJob(ctx context.Context, job model.UploadJob, jobErr error) error
+	SetUploadJobSubmitted(ctx context.Context, job model.UploadJob, txId, wallet string) error
+	FinalizeUploadJobs(ctx context.Context, fileId int64) error
+	RecoverUploadJobs(ctx context.Context, instance string) (int64, error)
+	ListUploadJobs(ctx context.Context, userId int64) ([]model.UploadJob, error)
+	CreateUploadSession(ctx context.Context, userId int64, filename string, folderId, size int64) (model.UploadSession, error)
+	GetUploadSession(ctx context.Context, userId int64, id string) (model.UploadSession, error)
+	SetUploadSessionOffset(ctx context.Context, id string, offset int64) error
+	DeleteUploadSession(ctx context.Context, id string) error
+	GetStaleUploadSessions(ctx context.Context, duration time.Duration) ([]model.UploadSession, error)
+
+	// download cache
+	NewDownloadedFile(ctx context.Context, file model.File, instance string) (int64, error)
+	ResetInterruptedDownloads(ctx context.Context, instance string) (int64, error)
+	GetExpiredDownloadedFiles(ctx context.Context, duration time.Duration) ([]model.File, error)
+	FinishDownloadedFile(ctx context.Context, id int64) error
+	FailDownloadedFile(ctx context.Context, id int64) error
+	ListDownloadedFiles(ctx context.Context, userId int64) ([]model.DownloadedFile, error)
+	RemoveDownloadedFile(ctx context.Context, fileId int64) error
+	IsCacheShared(ctx context.Context, id int64, hash string) (bool, error)
+	CheckIsFileAlreadyDownloaded(ctx context.Context, userId int64, hash string) (bool, error)
+	CheckDownloadStatus(ctx context.Context, userId int64, hash string) (bool, error)
+
+	// accounts and sessions
+	CreateUser(ctx context.Context, username, password string) (model.User, error)
+	CreateWalletUser(ctx context.Context, address common.Address) (model.User, error)
+	CountUsers(ctx context.Context) (int, error)
+	GetWalletUser(ctx context.Context, address common.Address) (model.User, error)
+	GetUserByName(ctx context.Context, username string) (model.User, error)
+	Authenticate(ctx context.Context, username, password string) (model.User, error)
+	CreateSession(ctx context.Context, userId int64, ttl time.Duration) (string, time.Time, error)
+	GetSessionUser(ctx context.Context, token string) (model.User, error)
+	DeleteSession(ctx context.Context, token string) error
+	CreateSiweNonce(ctx context.Context, ttl time.Duration) (string, error)
+	ConsumeSiweNonce(ctx context.Context, nonce string) error
+
+	// share links
+	CreateShare(ctx context.Context, userId, fileId int64, permission, password string, expiresAt *time.Time, maxDownloads int64) (model.Share, error)
+	ListShares(ctx context.Context, userId int64) ([]model.Share, error)
+	DeleteShare(ctx context.Context, userId, shareId int64) error
+	OpenShare(ctx context.Context, token, password string) (model.Share, model.File, error)
+	CountShareBytes(ctx context.Context, shareId, n, size int64) error
+
+	// quotas and costs
+	GetQuota(ctx context.Context, userId int64, defaults model.QuotaLimits) (model.Quota, error)
+	ListQuotas(ctx context.Context, defaults model.QuotaLimits) ([]model.Quota, error)
+	SetQuota(ctx context.Context, userId int64, maxBytes, maxFiles, maxUploadsPerDay *int64) error
+	CheckQuota(ctx context.Context, userId, size int64, defaults model.QuotaLimits) error
+	GetWalletQuota(ctx context.Context, wallet string, defaults model.WalletLimits) (model.WalletQuota, error)
+	SetWalletQuota(ctx context.Context, wallet string, maxBytesPerDay, maxSubmissionsPerDay *int64) error
+	ReserveWalletQuota(ctx context.Context, wallet string, size int64, defaults model.WalletLimits) error
+	AddFileCost(ctx context.Context, file model.File, cost model.TxCost) error
+	GetFileCost(ctx context.Context, userId, fileId int64) (model.FileCost, error)
+	CostReport(ctx context.Context, userId int64, from, to string) ([]model.CostReportRow, error)
+
+	// metadata backups
+	Snapshot(ctx context.Context) (model.Snapshot, error)
+	RestoreSnapshot(ctx context.Context, snapshot model.Snapshot) error
+	AddBackup(ctx context.Context, backup model.Backup) (model.Backup, error)
+	ListBackups(ctx context.Context) ([]model.Backup, error)
+	LatestBackup(ctx context.Context) (model.Backup, error)
+
+	// schema
+	SchemaVersion(ctx context.Context) (int, error)
+	Migrations(ctx context.Context) ([]model.Migration, error)
+	MigrateTo(ctx context.Context, version int) error
+	Close() error
+}
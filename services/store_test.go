@@ -0,0 +1,612 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"zgdrive/model"
+)
+
+// The store tests run every case against SQLite and Postgres, so the
+// queries, the schema and the rebinding between the two are checked
+// together. Postgres is the server TEST_DATABASE_URL names; without it the
+// Postgres cases are skipped, or fail when CI is set so a CI run can't
+// pass without them. Each case gets a freshly migrated database; on
+// Postgres that is a schema of its own, dropped afterwards.
+
+type storeBackend struct {
+	name string
+	// available skips or fails the backend's cases when it can't run
+	available func(t *testing.T)
+	open      func(t *testing.T) *DBService
+}
+
+func storeBackends() []storeBackend {
+	return []storeBackend{
+		{"sqlite", func(t *testing.T) {}, openSQLiteStore},
+		{"postgres", requirePostgres, func(t *testing.T) *DBService {
+			return openPostgresStore(t, os.Getenv("TEST_DATABASE_URL"))
+		}},
+	}
+}
+
+func requirePostgres(t *testing.T) {
+	if os.Getenv("TEST_DATABASE_URL") != "" {
+		return
+	}
+	if os.Getenv("CI") != "" {
+		t.Fatal("TEST_DATABASE_URL is not set, CI runs have to test the store against Postgres")
+	}
+	t.Skip("SKIPPING POSTGRES: set TEST_DATABASE_URL to a postgres:// URL to run the store tests against Postgres")
+}
+
+func openSQLiteStore(t *testing.T) *DBService {
+	return openMigratedStore(t, filepath.Join(t.TempDir(), "files.db"))
+}
+
+func openPostgresStore(t *testing.T, url string) *DBService {
+	admin, err := openDatabase(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("zgdrive_test_%d", time.Now().UnixNano())
+	_, err = admin.Exec(`CREATE SCHEMA ` + schema)
+	if err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+		admin.Close()
+	})
+
+	separator := "?"
+	if strings.Contains(url, "?") {
+		separator = "&"
+	}
+	return openMigratedStore(t, url+separator+"search_path="+schema)
+}
+
+func openMigratedStore(t *testing.T, dsn string) *DBService {
+	t.Helper()
+	d, err := OpenDBService(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	err = d.MigrateTo(context.Background(), LatestSchemaVersion())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+var storeTests = []struct {
+	name string
+	run  func(t *testing.T, d *DBService, open func(t *testing.T) *DBService)
+}{
+	{"migrations", testMigrations},
+	{"folders", testFolders},
+	{"files and quotas", testFilesAndQuotas},
+	{"upload jobs", testUploadJobs},
+	{"upload job order", testUploadJobOrder},
+	{"downloads", testDownloads},
+	{"shares", testShares},
+	{"wallet quotas", testWalletQuotas},
+	{"costs", testCosts},
+	{"snapshot", testSnapshot},
+}
+
+func TestStore(t *testing.T) {
+	for _, backend := range storeBackends() {
+		t.Run(backend.name, func(t *testing.T) {
+			backend.available(t)
+			for _, test := range storeTests {
+				t.Run(test.name, func(t *testing.T) {
+					test.run(t, backend.open(t), backend.open)
+				})
+			}
+		})
+	}
+}
+
+func TestRebindPostgres(t *testing.T) {
+	query := `SELECT '?', a FROM t WHERE b = ? AND c < ` + sqliteNow + ` AND d >= ` + sqliteToday + ` AND e IN (?, ?)`
+	want := `SELECT '?', a FROM t WHERE b = $1 AND c < CURRENT_TIMESTAMP AND d >= date_trunc('day', CURRENT_TIMESTAMP) AND e IN ($2, $3)`
+	if got := rebindPostgres(query); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+	if got := sqliteDialect.rebind(query); got != query {
+		t.Errorf("SQLite queries must not be rewritten, got %s", got)
+	}
+}
+
+func createTestUser(t *testing.T, d *DBService, name string) model.User {
+	t.Helper()
+	user, err := d.CreateUser(context.Background(), name, "correct-horse-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func addTestFile(t *testing.T, d *DBService, file model.File) model.File {
+	t.Helper()
+	file, err := d.AddFile(context.Background(), file, model.QuotaLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func testMigrations(t *testing.T, d *DBService, _ func(t *testing.T) *DBService) {
+	ctx := context.Background()
+	createTestUser(t, d, "alice")
+
+	// every down migration has to run on a database that holds data
+	err := d.MigrateTo(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, err := d.SchemaVersion(ctx)
+	if err != nil || version != 0 {
+		t.Fatalf("got version %d, %v after migrating down", version, err)
+	}
+	err = d.MigrateTo(ctx, LatestSchemaVersion())
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrations, err := d.Migrations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range migrations {
+		if m.AppliedAt == nil {
+			t.Errorf("migration %d was not applied again", m.Version)
+		}
+	}
+	createTestUser(t, d, "alice")
+}
+
+func testFolders(t *testing.T, d *DBService, _ func(t *testing.T) *DBService) {
+	ctx := context.Background()
+	user := createTestUser(t, d, "alice")
+
+	a, err := d.CreateFolder(ctx, user.ID, "a", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := d.CreateFolder(ctx, user.ID, "b", a.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.CreateFolder(ctx, user.ID, "b", a.ID)
+	if !errors.Is(err, ErrFolderExists) {
+		t.Errorf("creating a duplicate folder got %v", err)
+	}
+
+	for _, parent := range []int64{a.ID, b.ID} {
+		err = d.MoveFolder(ctx, user.ID, a.ID, parent)
+		if !errors.Is(err, ErrFolderCycle) {
+			t.Errorf("moving a folder under %d got %v, want a cycle error", parent, err)
+		}
+	}
+
+	c, err := d.CreateFolder(ctx, user.ID, "c", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.MoveFolder(ctx, user.ID, b.ID, c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	folder, file, err := d.ResolvePath(ctx, user.ID, "/c/b")
+	if err != nil || file != nil || folder == nil || folder.ID != b.ID {
+		t.Fatalf("resolving /c/b got %v, %v, %v", folder, file, err)
+	}
+	err = d.MoveFolder(ctx, user.ID, b.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := d.ListFolder(ctx, user.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contents.Folders) != 3 {
+		t.Errorf("the root holds %d folders, want 3", len(contents.Folders))
+	}
+}
+
+func testFilesAndQuotas(t *testing.T, d *DBService, _ func(t *testing.T) *DBService) {
+	ctx := context.Background()
+	user := createTestUser(t, d, "alice")
+	limits := model.QuotaLimits{MaxBytes: 25}
+
+	first, err := d.AddFile(ctx, model.File{OwnerId: user.ID, Filename: "a.txt", Hash: "0x01", Size: 10}, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := d.AddFile(ctx, model.File{OwnerId: user.ID, Filename: "a.txt", Hash: "0x02", Size: 10}, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Version != 2 || second.LogicalId != first.ID {
+		t.Errorf("got version %d of %d, want version 2 of %d", second.Version, second.LogicalId, first.ID)
+	}
+	versions, err := d.ListVersions(ctx, user.ID, second.ID)
+	if err != nil || len(versions) != 2 {
+		t.Fatalf("got %d versions, %v", len(versions), err)
+	}
+
+	// every version counts towards the stored bytes
+	_, err = d.AddFile(ctx, model.File{OwnerId: user.ID, Filename: "b.txt", Hash: "0x03", Size: 10}, limits)
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("adding past the byte limit got %v", err)
+	}
+
+	uploads := int64(2)
+	err = d.SetQuota(ctx, user.ID, nil, nil, &uploads)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quota, err := d.GetQuota(ctx, user.ID, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !quota.Custom || quota.MaxBytes != 25 || quota.UsedBytes != 20 || quota.Files != 1 || quota.UploadsToday != 2 {
+		t.Errorf("got quota %+v", quota)
+	}
+	err = d.CheckQuota(ctx, user.ID, 1, limits)
+	if !errors.Is(err, ErrUploadLimit) {
+		t.Errorf("uploading past the daily limit got %v", err)
+	}
+}
+
+func testUploadJobs(t *testing.T, d *DBService, _ func(t *testing.T) *DBService) {
+	ctx := context.Background()
+	user := createTestUser(t, d, "alice")
+	file := addTestFile(t, d, model.File{OwnerId: user.ID, Filename: "a.txt", Hash: "0x01", Size: 10})
+	_, err := d.AddUploadJob(ctx, file.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobState := func() string {
+		t.Helper()
+		jobs, err := d.ListUploadJobs(ctx, user.ID)
+		if err != nil || len(jobs) != 1 {
+			t.Fatalf("got %d jobs, %v", len(jobs), err)
+		}
+		return jobs[0].State
+	}
+
+	job, err := d.ClaimNextUploadJob(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.ClaimNextUploadJob(ctx, "b")
+	if err == nil {
+		t.Error("a claimed job was claimed again")
+	}
+
+	// another instance starting up leaves the job alone
+	n, err := d.RecoverUploadJobs(ctx, "b")
+	if err != nil || n != 0 || jobState() != model.UploadJobSubmitting {
+		t.Errorf("recovering another instance's job got %d, %v", n, err)
+	}
+	n, err = d.RecoverUploadJobs(ctx, "a")
+	if err != nil || n != 1 || jobState() != model.UploadJobQueued {
+		t.Errorf("recovering the instance's own job got %d, %v", n, err)
+	}
+
+	job, err = d.ClaimNextUploadJob(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.SetUploadJobSubmitted(ctx, job, "0xabc", "0x0000000000000000000000000000000000000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err = d.GetFileById(ctx, file.ID)
+	if err != nil || file.TxId != "0xabc" || jobState() != model.UploadJobSubmitted {
+		t.Fatalf("got tx %q, %v", file.TxId, err)
+	}
+	err = d.SetUploaded(ctx, file.ID)
+	if err == nil {
+		err = d.FinalizeUploadJobs(ctx, file.ID)
+	}
+	if err != nil || jobState() != model.UploadJobFinalized {
+		t.Fatalf("finalizing got %v", err)
+	}
+
+	// a file that was just added may be about to get its job
+	addTestFile(t, d, model.File{OwnerId: user.ID, Filename: "b.txt", Hash: "0x02", Size: 10})
+	n, err = d.RecoverUploadJobs(ctx, "b")
+	if err != nil || n != 0 {
+		t.Errorf("a new file without a job got %d jobs, %v", n, err)
+	}
+}
+
+func testUploadJobOrder(t *testing.T, d *DBService, _ func(t *testing.T) *DBService) {
+	ctx := context.Background()
+	alice := createTestUser(t, d, "alice")
+	bob := createTestUser(t, d, "bob")
+	addJob := func(file model.File) model.File {
+		t.Helper()
+		file = addTestFile(t, d, file)
+		_, err := d.AddUploadJob(ctx, file.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	first := addJob(model.File{OwnerId: alice.ID, Filename: "report.pdf", Hash: "0x01", Size: 10})
+	addJob(model.File{OwnerId: alice.ID, Filename: "report.pdf", Hash: "0x02", Size: 10})
+	addJob(model.File{OwnerId: alice.ID, Filename: "copy.pdf", Hash: "0x01", Size: 10})
+	other := addJob(model.File{OwnerId: bob.ID, Filename: "report.pdf", Hash: "0x01", Size: 10})
+
+	job, err := d.ClaimNextUploadJob(ctx, "a")
+	if err != nil || job.FileId != first.ID {
+		t.Fatalf("claimed file %d, %v, want %d", job.FileId, err, first.ID)
+	}
+	// the next version and alice's copy of the content wait for it, bob's
+	// file of the same name and content doesn't
+	job, err = d.ClaimNextUploadJob(ctx, "a")
+	if err != nil || job.FileId != other.ID {
+		t.Fatalf("claimed file %d, %v, want %d", job.FileId, err, other.ID)
+	}
+	_, err = d.ClaimNextUploadJob(ctx, "a")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("claimed a job waiting for its file, %v", err)
+	}
+}
+
+func testDownloads(t *testing.T, d *DBService, _ func(t *testing.T) *DBService) {
+	ctx := context.Background()
+	alice := createTestUser(t, d, "alice")
+	bob := createTestUser(t, d, "bob")
+	a := addTestFile(t, d, model.File{OwnerId: alice.ID, Filename: "a.txt", Hash: "0x01", Size: 10})
+	b := addTestFile(t, d, model.File{OwnerId: bob.ID, Filename: "b.txt", Hash: "0x01", Size: 10})
+
+	first, err := d.NewDownloadedFile(ctx, a, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := d.NewDownloadedFile(ctx, b, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := d.ResetInterruptedDownloads(ctx, "a")
+	if err != nil || n != 1 {
+		t.Fatalf("resetting the instance's downloads got %d, %v", n, err)
+	}
+	shared, err := d.IsCacheShared(ctx, first, "0x01")
+	if err != nil || !shared {
+		t.Errorf("bob's download of the same content got %t, %v", shared, err)
+	}
+	_, err = d.NewDownloadedFile(ctx, b, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.FinishDownloadedFile(ctx, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done, err := d.CheckDownloadStatus(ctx, bob.ID, "0x01")
+	if err != nil || !done {
+		t.Errorf("bob's finished download got %t, %v", done, err)
+	}
+	// finishing one download leaves the other requests of the file queued
+	n, err = d.ResetInterruptedDownloads(ctx, "b")
+	if err != nil || n != 1 {
+		t.Errorf("resetting bob's other download got %d, %v", n, err)
+	}
+	err = d.RemoveDownloadedFile(ctx, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, err = d.IsCacheShared(ctx, first, "0x01")
+	if err != nil || shared {
+		t.Errorf("with every other download removed got %t, %v", shared, err)
+	}
+}
+
+func testShares(t *testing.T, d *DBService, _ func(t *testing.T) *DBService) {
+	ctx := context.Background()
+	user := createTestUser(t, d, "alice")
+	file := addTestFile(t, d, model.File{OwnerId: user.ID, Filename: "a.txt", Hash: "0x01", Size: 100})
+	expires := time.Now().Add(time.Hour)
+	share, err := d.CreateShare(ctx, user.ID, file.ID, model.SharePermissionDownload, "secret-pw", &expires, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = d.OpenShare(ctx, share.Token, "wrong")
+	if !errors.Is(err, ErrSharePassword) {
+		t.Errorf("opening with a wrong password got %v", err)
+	}
+	_, opened, err := d.OpenShare(ctx, share.Token, "secret-pw")
+	if err != nil || opened.ID != file.ID {
+		t.Fatalf("opening got file %d, %v", opened.ID, err)
+	}
+
+	// two downloads split into ranges use up the link like two whole ones
+	for i := 0; i < 4; i++ {
+		err = d.CountShareBytes(ctx, share.ID, 50, file.Size)
+		if err != nil {
+			t.Fatalf("range %d got %v", i, err)
+		}
+	}
+	err = d.CountShareBytes(ctx, share.ID, 1, file.Size)
+	if !errors.Is(err, ErrShareExhausted) {
+		t.Errorf("a byte past the limit got %v", err)
+	}
+	_, _, err = d.OpenShare(ctx, share.Token, "secret-pw")
+	if !errors.Is(err, ErrShareExhausted) {
+		t.Errorf("opening a used up link got %v", err)
+	}
+	shares, err := d.ListShares(ctx, user.ID)
+	if err != nil || len(shares) != 1 || shares[0].Downloads != 2 || shares[0].BytesServed != 200 {
+		t.Fatalf("got shares %+v, %v", shares, err)
+	}
+
+	expired := time.Now().Add(-time.Minute)
+	share, err = d.CreateShare(ctx, user.ID, file.ID, model.SharePermissionView, "", &expired, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = d.OpenShare(ctx, share.Token, "")
+	if !errors.Is(err, ErrShareExpired) {
+		t.Errorf("opening an expired link got %v", err)
+	}
+}
+
+func testWalletQuotas(t *testing.T, d *DBService, _ func(t *testing.T) *DBService) {
+	ctx := context.Background()
+	wallet := "0x0000000000000000000000000000000000000001"
+	limits := model.WalletLimits{MaxBytesPerDay: 100, MaxSubmissionsPerDay: 2}
+
+	err := d.ReserveWalletQuota(ctx, wallet, 60, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.ReserveWalletQuota(ctx, wallet, 60, limits)
+	if !errors.Is(err, ErrWalletLimit) {
+		t.Errorf("submitting past the byte limit got %v", err)
+	}
+	err = d.ReserveWalletQuota(ctx, wallet, 200, limits)
+	if !errors.Is(err, ErrWalletFileTooLarge) {
+		t.Errorf("submitting more than a day allows got %v", err)
+	}
+	err = d.ReserveWalletQuota(ctx, wallet, 30, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.ReserveWalletQuota(ctx, wallet, 1, limits)
+	if !errors.Is(err, ErrWalletLimit) {
+		t.Errorf("submitting past the submission limit got %v", err)
+	}
+
+	submissions := int64(5)
+	err = d.SetWalletQuota(ctx, wallet, nil, &submissions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quota, err := d.GetWalletQuota(ctx, wallet, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := model.WalletQuota{
+		Wallet:           wallet,
+		WalletLimits:     model.WalletLimits{MaxBytesPerDay: 100, MaxSubmissionsPerDay: 5},
+		Custom:           true,
+		BytesToday:       90,
+		SubmissionsToday: 2,
+	}
+	if quota != want {
+		t.Errorf("got %+v, want %+v", quota, want)
+	}
+}
+
+func testCosts(t *testing.T, d *DBService, _ func(t *testing.T) *DBService) {
+	ctx := context.Background()
+	user := createTestUser(t, d, "alice")
+	file := addTestFile(t, d, model.File{OwnerId: user.ID, Filename: "a.txt", Hash: "0x01", Size: 10, TxId: "0xabc"})
+	err := d.AddFileCost(ctx, file, model.TxCost{TxId: "0xabc", StorageFee: "100", GasUsed: 21000, GasPrice: "1", GasFee: "21000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cost, err := d.GetFileCost(ctx, user.ID, file.ID)
+	if err != nil || cost.Total != "21100" {
+		t.Fatalf("got cost %+v, %v", cost, err)
+	}
+	today := time.Now().Format(time.DateOnly)
+	report, err := d.CostReport(ctx, user.ID, today, today)
+	if err != nil || len(report) != 1 || report[0].Files != 1 || report[0].Total != "21100" {
+		t.Fatalf("got report %+v, %v", report, err)
+	}
+}
+
+func testSnapshot(t *testing.T, d *DBService, open func(t *testing.T) *DBService) {
+	ctx := context.Background()
+	user := createTestUser(t, d, "alice")
+	folder, err := d.CreateFolder(ctx, user.ID, "docs", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := addTestFile(t, d, model.File{OwnerId: user.ID, Filename: "a.txt", FolderId: folder.ID, Hash: "0x01", Size: 10})
+	_, err = d.CreateShare(ctx, user.ID, file.ID, model.SharePermissionView, "", nil, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	maxFiles := int64(10)
+	err = d.SetQuota(ctx, user.ID, nil, &maxFiles, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := d.Snapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = WriteSnapshot(io.Discard, snapshot, nil)
+	if !errors.Is(err, ErrSnapshotUnencrypted) {
+		t.Errorf("writing a snapshot without a key got %v", err)
+	}
+	var written bytes.Buffer
+	encryptor := &Encryptor{masterKey: bytes.Repeat([]byte{7}, 32)}
+	err = WriteSnapshot(&written, snapshot, encryptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadSnapshot(&written, encryptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// older snapshots kept the upload jobs, which are dropped now
+	read.Tables = append(read.Tables, model.SnapshotTable{
+		Name:    "upload_jobs",
+		Columns: []string{"id", "file_id"},
+		Rows:    [][]any{{json.Number("1"), json.Number(fmt.Sprint(file.ID))}},
+	})
+
+	restored := open(t)
+	err = restored.RestoreSnapshot(ctx, read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := restored.ListUploadJobs(ctx, user.ID)
+	if err != nil || len(jobs) != 0 {
+		t.Errorf("restored %d upload jobs, %v", len(jobs), err)
+	}
+	again, err := restored.Snapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := json.Marshal(snapshot)
+	got, _ := json.Marshal(again)
+	if string(got) != string(want) {
+		t.Errorf("the restored database snapshots differently:\n%s\nwant\n%s", got, want)
+	}
+
+	// ids continue after the restored rows
+	next := addTestFile(t, restored, model.File{OwnerId: user.ID, Filename: "b.txt", Hash: "0x02", Size: 10})
+	if next.ID <= file.ID {
+		t.Errorf("a file added after the restore got id %d, the restored one has %d", next.ID, file.ID)
+	}
+	err = restored.RestoreSnapshot(ctx, snapshot)
+	if !errors.Is(err, ErrRestoreNotEmpty) {
+		t.Errorf("restoring into a database in use got %v", err)
+	}
+}
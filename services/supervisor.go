@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+	"zgdrive/model"
+)
+
+const (
+	minBackoff = 1 * time.Second
+	maxBackoff = 5 * time.Minute
+)
+
+// Supervisor runs background workers and keeps them alive. A worker is a
+// step function that is called in a loop; when it returns an error the
+// error is logged and recorded, and the next call is delayed with
+// exponential backoff and jitter. Panics are treated like errors.
+type Supervisor struct {
+	mu      sync.Mutex
+	workers map[string]*model.WorkerHealth
+}
+
+func NewSupervisor() *Supervisor {
+	return &Supervisor{
+		workers: make(map[string]*model.WorkerHealth),
+	}
+}
+
+// Go starts a supervised worker. interval is how long to wait between two
+// successful steps; steps that block on their own can pass 0.
+func (s *Supervisor) Go(ctx context.Context, name string, interval time.Duration, step func(ctx context.Context) error) {
+	s.mu.Lock()
+	s.workers[name] = &model.WorkerHealth{Name: name, State: model.WorkerRunning}
+	s.mu.Unlock()
+
+	go func() {
+		defer s.update(name, func(h *model.WorkerHealth) {
+			h.State = model.WorkerStopped
+		})
+
+		failures := 0
+		for {
+			err := runStep(ctx, step)
+			wait := interval
+			if err != nil {
+				failures++
+				wait = backoff(failures)
+				fmt.Printf("Worker %s failed (attempt %d), retrying in %s: %v\n", name, failures, wait, err)
+				s.update(name, func(h *model.WorkerHealth) {
+					h.State = model.WorkerBackingOff
+					h.ConsecutiveFailures = failures
+					h.LastError = err.Error()
+					h.LastErrorAt = time.Now()
+					h.NextRetryAt = time.Now().Add(wait)
+				})
+			} else if failures > 0 {
+				failures = 0
+				s.update(name, func(h *model.WorkerHealth) {
+					h.State = model.WorkerRunning
+					h.ConsecutiveFailures = 0
+					h.NextRetryAt = time.Time{}
+				})
+			}
+
+			if wait == 0 {
+				if ctx.Err() != nil {
+					return
+				}
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+	}()
+}
+
+// Health returns a snapshot of every worker, sorted by name.
+func (s *Supervisor) Health() []model.WorkerHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	health := make([]model.WorkerHealth, 0, len(s.workers))
+	for _, h := range s.workers {
+		health = append(health, *h)
+	}
+	sort.Slice(health, func(i, j int) bool {
+		return health[i].Name < health[j].Name
+	})
+	return health
+}
+
+// Healthy reports whether every worker is running normally.
+func (s *Supervisor) Healthy() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, h := range s.workers {
+		if h.State != model.WorkerRunning {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Supervisor) update(name string, fn func(h *model.WorkerHealth)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.workers[name])
+}
+
+func runStep(ctx context.Context, step func(ctx context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return step(ctx)
+}
+
+// backoff returns an exponential delay for the given failure count, with
+// jitter spreading it between half and the full value.
+func backoff(failures int) time.Duration {
+	d := minBackoff
+	for i := 1; i < failures && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
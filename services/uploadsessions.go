@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+	"zgdrive/model"
+)
+
+const uploadSessionColumns = `id, filename, COALESCE(folder_id, 0), size, upload_offset, created_at, updated_at`
+
+func scanUploadSession(row rowScanner) (model.UploadSession, error) {
+	var s model.UploadSession
+	err := row.Scan(&s.ID, &s.Filename, &s.FolderId, &s.Size, &s.Offset, &s.CreatedAt, &s.UpdatedAt)
+	if err != nil {
+		return model.UploadSession{}, err
+	}
+	return s, nil
+}
+
+// CreateUploadSession starts a resumable upload of size bytes for userId.
+// Its id is random so it can't be guessed.
+func (d *DBService) CreateUploadSession(ctx context.Context, userId int64, filename string, folderId, size int64) (model.UploadSession, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return model.UploadSession{}, err
+	}
+
+	query := `
+		INSERT INTO upload_sessions (id, owner_id, filename, folder_id, size)
+		VALUES (?, ?, ?, NULLIF(?, 0), ?) RETURNING ` + uploadSessionColumns
+	return scanUploadSession(d.db.QueryRowContext(ctx, query, hex.EncodeToString(buf), userId, filename, folderId, size))
+}
+
+// GetUploadSession returns an upload session of userId.
+func (d *DBService) GetUploadSession(ctx context.Context, userId int64, id string) (model.UploadSession, error) {
+	query := `
+		SELECT ` + uploadSessionColumns + `
+		FROM upload_sessions
+		WHERE id = ? AND owner_id = ?
+	`
+	return scanUploadSession(d.db.QueryRowContext(ctx, query, id, userId))
+}
+
+// SetUploadSessionOffset records how many bytes of the upload have been
+// received.
+func (d *DBService) SetUploadSessionOffset(ctx context.Context, id string, offset int64) error {
+	query := `
+		UPDATE upload_sessions
+		SET upload_offset = ?, updated_at = datetime('now','localtime')
+		WHERE id = ?
+	`
+	result, err := d.db.ExecContext(ctx, query, offset, id)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(result)
+}
+
+func (d *DBService) DeleteUploadSession(ctx context.Context, id string) error {
+	result, err := d.db.ExecContext(ctx, `DELETE FROM upload_sessions WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(result)
+}
+
+// GetStaleUploadSessions returns sessions that received nothing for longer
+// than duration.
+func (d *DBService) GetStaleUploadSessions(ctx context.Context, duration time.Duration) ([]model.UploadSession, error) {
+	query := `
+		SELECT ` + uploadSessionColumns + `
+		FROM upload_sessions
+		WHERE updated_at < ?
+	`
+	rows, err := d.db.QueryContext(ctx, query, time.Now().Add(-duration))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []model.UploadSession{}
+	for rows.Next() {
+		s, err := scanUploadSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+	"zgdrive/model"
+
+	"github.com/ethereum/go-ethereum/common"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Users sign in with a username and password, or with an Ethereum wallet
+// (see siwe.go), and get a random session token. Only a hash of the token
+// is stored, so a leaked database can't be used to take over sessions.
+
+var (
+	ErrUserExists         = errors.New("username is already taken")
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrWeakPassword       = errors.New("password must be at least 8 characters")
+)
+
+// CreateUser adds an account. The first account created is the admin and
+// takes over the files and folders recorded before accounts existed.
+func (d *DBService) CreateUser(ctx context.Context, username, password string) (model.User, error) {
+	// wallet accounts are named after their address
+	if !validName(username) || common.IsHexAddress(username) {
+		return model.User{}, ErrInvalidName
+	}
+	if len(password) < 8 {
+		return model.User{}, ErrWeakPassword
+	}
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return model.User{}, err
+	}
+	return d.createUser(ctx, username, string(passwordHash), sql.NullString{})
+}
+
+// CreateWalletUser adds an account that signs in with the wallet at
+// address. It has no password.
+func (d *DBService) CreateWalletUser(ctx context.Context, address common.Address) (model.User, error) {
+	return d.createUser(ctx, address.Hex(), "", sql.NullString{String: address.Hex(), Valid: true})
+}
+
+func (d *DBService) createUser(ctx context.Context, username, passwordHash string, address sql.NullString) (model.User, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return model.User{}, err
+	}
+	defer tx.Rollback()
+
+	user := model.User{Username: username, Address: address.String}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO users (username, password_hash, address)
+		VALUES (?, ?, ?) RETURNING id, created_at
+	`, username, passwordHash, address).Scan(&user.ID, &user.CreatedAt)
+	if isUniqueViolation(err) {
+		return model.User{}, ErrUserExists
+	}
+	if err != nil {
+		return model.User{}, err
+	}
+
+	var count int
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM users`).Scan(&count)
+	if err != nil {
+		return model.User{}, err
+	}
+	if count == 1 {
+		user.IsAdmin = true
+		_, err = tx.ExecContext(ctx, `UPDATE users SET is_admin = TRUE WHERE id = ?`, user.ID)
+		if err != nil {
+			return model.User{}, err
+		}
+		for _, table := range []string{"files", "downloaded_files", "folders", "upload_sessions"} {
+			_, err = tx.ExecContext(ctx, `UPDATE `+table+` SET owner_id = ? WHERE owner_id IS NULL`, user.ID)
+			if err != nil {
+				return model.User{}, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return model.User{}, err
+	}
+	return user, nil
+}
+
+// CountUsers returns how many accounts exist.
+func (d *DBService) CountUsers(ctx context.Context) (int, error) {
+	var count int
+	err := d.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users`).Scan(&count)
+	return count, err
+}
+
+// GetWalletUser returns the account of the wallet at address, or
+// sql.ErrNoRows when it has none yet.
+func (d *DBService) GetWalletUser(ctx context.Context, address common.Address) (model.User, error) {
+	var user model.User
+	err := d.db.QueryRowContext(ctx, `
+		SELECT id, username, address, is_admin, created_at
+		FROM users
+		WHERE address = ?
+	`, address.Hex()).Scan(&user.ID, &user.Username, &user.Address, &user.IsAdmin, &user.CreatedAt)
+	if err != nil {
+		return model.User{}, err
+	}
+	return user, nil
+}
+
+// GetUserByName returns the account with the given username, which for a
+// wallet account is its address.
+func (d *DBService) GetUserByName(ctx context.Context, username string) (model.User, error) {
+	var user model.User
+	err := d.db.QueryRowContext(ctx, `
+		SELECT id, username, COALESCE(address, ''), is_admin, created_at
+		FROM users
+		WHERE username = ?
+	`, username).Scan(&user.ID, &user.Username, &user.Address, &user.IsAdmin, &user.CreatedAt)
+	if err != nil {
+		return model.User{}, err
+	}
+	return user, nil
+}
+
+// Authenticate checks a username and password. Wallet accounts have no
+// password and never match.
+func (d *DBService) Authenticate(ctx context.Context, username, password string) (model.User, error) {
+	user := model.User{Username: username}
+	var passwordHash string
+	err := d.db.QueryRowContext(ctx, `
+		SELECT id, password_hash, is_admin, created_at
+		FROM users
+		WHERE username = ? AND address IS NULL
+	`, username).Scan(&user.ID, &passwordHash, &user.IsAdmin, &user.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.User{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return model.User{}, err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password))
+	if err != nil {
+		return model.User{}, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+// CreateSession signs userId in for ttl and returns the session token.
+// Expired sessions are dropped on the way.
+func (d *DBService) CreateSession(ctx context.Context, userId int64, ttl time.Duration) (string, time.Time, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	token := hex.EncodeToString(buf)
+	expiresAt := time.Now().Add(ttl)
+
+	_, err = d.db.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at < ?`, time.Now())
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	_, err = d.db.ExecContext(ctx, `
+		INSERT INTO sessions (token_hash, user_id, expires_at)
+		VALUES (?, ?, ?)
+	`, hashToken(token), userId, expiresAt)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// GetSessionUser returns the user signed in with token, or sql.ErrNoRows
+// when the token is unknown or expired.
+func (d *DBService) GetSessionUser(ctx context.Context, token string) (model.User, error) {
+	var user model.User
+	err := d.db.QueryRowContext(ctx, `
+		SELECT u.id, u.username, COALESCE(u.address, ''), u.is_admin, u.created_at
+		FROM sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = ? AND s.expires_at > ?
+	`, hashToken(token), time.Now()).Scan(&user.ID, &user.Username, &user.Address, &user.IsAdmin, &user.CreatedAt)
+	if err != nil {
+		return model.User{}, err
+	}
+	return user, nil
+}
+
+// DeleteSession signs a session out.
+func (d *DBService) DeleteSession(ctx context.Context, token string) error {
+	_, err := d.db.ExecContext(ctx, `DELETE FROM sessions WHERE token_hash = ?`, hashToken(token))
+	return err
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
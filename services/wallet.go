@@ -0,0 +1,128 @@
+package services
+
+import (
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+	"zgdrive/model"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// insufficientFunds is how nodes reject a transaction the wallet can't pay
+// for, and the state shown while submissions are paused.
+const insufficientFunds = "insufficient funds"
+
+// WalletMonitor keeps the last known balance of the paying wallets and
+// decides which of them may send new submissions.
+type WalletMonitor struct {
+	mu         sync.Mutex
+	minBalance *big.Int
+	wallets    []common.Address
+	states     map[common.Address]*walletState
+}
+
+type walletState struct {
+	status model.WalletStatus
+	// balance when a submission failed for lack of funds, it has to grow
+	// before the wallet is used again
+	failedAt *big.Int
+}
+
+// NewWalletMonitor returns a monitor that stops using a wallet while its
+// balance is below minBalance. Until the first check, every wallet is used.
+func NewWalletMonitor(minBalance *big.Int, wallets []common.Address) *WalletMonitor {
+	states := map[common.Address]*walletState{}
+	for _, wallet := range wallets {
+		states[wallet] = &walletState{status: model.WalletStatus{
+			Address:    wallet.Hex(),
+			MinBalance: minBalance.String(),
+			Sufficient: true,
+		}}
+	}
+	return &WalletMonitor{minBalance: minBalance, wallets: wallets, states: states}
+}
+
+// Update records a balance check of a wallet. A failed check keeps the last
+// state, so an RPC hiccup doesn't pause uploads. It reports whether the
+// wallet couldn't pay before and may be used again now.
+func (m *WalletMonitor) Update(address common.Address, balance *big.Int, err error) (resumed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, ok := m.states[address]
+	if !ok {
+		return false
+	}
+	status := &state.status
+	status.CheckedAt = time.Now()
+	if err != nil {
+		status.Error = err.Error()
+		return false
+	}
+
+	wasSufficient := status.Sufficient
+	status.Balance = balance.String()
+	status.Sufficient = balance.Cmp(m.minBalance) >= 0 && (state.failedAt == nil || balance.Cmp(state.failedAt) > 0)
+	status.Error = ""
+	if status.Sufficient {
+		state.failedAt = nil
+	} else {
+		status.Error = insufficientFunds
+	}
+	return !wasSufficient && status.Sufficient
+}
+
+// MarkInsufficient stops using a wallet after one of its submissions failed
+// for lack of funds. It is used again once a balance check sees it topped
+// up.
+func (m *WalletMonitor) MarkInsufficient(address common.Address, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, ok := m.states[address]
+	if !ok {
+		return
+	}
+	state.status.Sufficient = false
+	state.status.Error = err.Error()
+	state.failedAt, _ = new(big.Int).SetString(state.status.Balance, 10)
+}
+
+// Usable reports whether a wallet may send new submissions.
+func (m *WalletMonitor) Usable(address common.Address) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, ok := m.states[address]
+	return ok && state.status.Sufficient
+}
+
+// Sufficient reports whether any wallet may send new submissions.
+func (m *WalletMonitor) Sufficient() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, state := range m.states {
+		if state.status.Sufficient {
+			return true
+		}
+	}
+	return false
+}
+
+// Statuses returns the status of every wallet, in configuration order.
+func (m *WalletMonitor) Statuses() []model.WalletStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	statuses := make([]model.WalletStatus, 0, len(m.wallets))
+	for _, wallet := range m.wallets {
+		statuses = append(statuses, m.states[wallet].status)
+	}
+	return statuses
+}
+
+// IsInsufficientFunds reports whether err is a node rejecting a
+// transaction the wallet can't pay for.
+func IsInsufficientFunds(err error) bool {
+	return err != nil && strings.Contains(strings.ToLower(err.Error()), insufficientFunds)
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Ways a WalletPool picks the wallet that pays for an upload.
+const (
+	// WalletSelectionRoundRobin takes turns between the wallets.
+	WalletSelectionRoundRobin = "round-robin"
+	// WalletSelectionPerUser always pays for a user's uploads with the
+	// same wallet, falling back to the others while it can't pay.
+	WalletSelectionPerUser = "per-user"
+	// WalletSelectionLeastPending takes the wallet with the fewest
+	// transactions waiting to be mined.
+	WalletSelectionLeastPending = "least-pending"
+)
+
+// ErrNoWallet is returned when none of the wallets can pay for an upload.
+var ErrNoWallet = errors.New("no wallet can pay for the upload")
+
+// WalletPool hands out the wallets that pay for submissions. A wallet
+// submits one file at a time, so the nonce the uploader reads from the node
+// is never taken by a concurrent submission from the same wallet. Uploads
+// run concurrently across wallets.
+type WalletPool struct {
+	mu        sync.Mutex
+	wallets   []common.Address
+	selection string
+	// pending returns how many transactions of a wallet are waiting to be
+	// mined, for least-pending selection
+	pending func(ctx context.Context, wallet common.Address) (uint64, error)
+	busy    map[common.Address]bool
+	next    int
+	// released is closed and replaced whenever a wallet is released
+	released chan struct{}
+}
+
+func NewWalletPool(wallets []common.Address, selection string, pending func(ctx context.Context, wallet common.Address) (uint64, error)) (*WalletPool, error) {
+	if len(wallets) == 0 {
+		return nil, fmt.Errorf("no wallets configured")
+	}
+	switch selection {
+	case "":
+		selection = WalletSelectionRoundRobin
+	case WalletSelectionRoundRobin, WalletSelectionPerUser, WalletSelectionLeastPending:
+	default:
+		return nil, fmt.Errorf("unknown wallet selection: %s", selection)
+	}
+
+	return &WalletPool{
+		wallets:   wallets,
+		selection: selection,
+		pending:   pending,
+		busy:      map[common.Address]bool{},
+		released:  make(chan struct{}),
+	}, nil
+}
+
+// Acquire picks a wallet that usable accepts to pay for an upload of
+// ownerId, waiting while every such wallet is submitting another file. It
+// returns ErrNoWallet when usable accepts none. The wallet must be
+// released once its submission is done.
+func (p *WalletPool) Acquire(ctx context.Context, ownerId int64, usable func(common.Address) bool) (common.Address, error) {
+	for {
+		// ask the node before locking, it can be slow
+		var pending map[common.Address]uint64
+		if p.selection == WalletSelectionLeastPending {
+			pending = p.pendingCounts(ctx)
+		}
+
+		p.mu.Lock()
+		wallet, ok, err := p.pick(ownerId, usable, pending)
+		if ok {
+			p.busy[wallet] = true
+		}
+		released := p.released
+		p.mu.Unlock()
+		if ok || err != nil {
+			return wallet, err
+		}
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return common.Address{}, ctx.Err()
+		}
+	}
+}
+
+// Release returns a wallet taken with Acquire to the pool.
+func (p *WalletPool) Release(wallet common.Address) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.busy, wallet)
+	close(p.released)
+	p.released = make(chan struct{})
+}
+
+// pick returns a free wallet, or false when the wallets that can pay are
+// all busy.
+func (p *WalletPool) pick(ownerId int64, usable func(common.Address) bool, pending map[common.Address]uint64) (common.Address, bool, error) {
+	var candidates []int
+	for i, wallet := range p.wallets {
+		if usable(wallet) {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		return common.Address{}, false, ErrNoWallet
+	}
+
+	if p.selection == WalletSelectionPerUser {
+		own := p.wallets[int(ownerId%int64(len(p.wallets)))]
+		if usable(own) {
+			// keep the user's uploads on their wallet even when it's busy
+			return own, !p.busy[own], nil
+		}
+	}
+
+	if p.selection == WalletSelectionLeastPending {
+		best := -1
+		for _, i := range candidates {
+			wallet := p.wallets[i]
+			if p.busy[wallet] {
+				continue
+			}
+			if best == -1 || pending[wallet] < pending[p.wallets[best]] {
+				best = i
+			}
+		}
+		if best == -1 {
+			return common.Address{}, false, nil
+		}
+		return p.wallets[best], true, nil
+	}
+
+	for range p.wallets {
+		i := p.next
+		p.next = (p.next + 1) % len(p.wallets)
+		wallet := p.wallets[i]
+		if usable(wallet) && !p.busy[wallet] {
+			return wallet, true, nil
+		}
+	}
+	return common.Address{}, false, nil
+}
+
+// pendingCounts returns the pending transactions of every wallet. A wallet
+// the node can't be asked about counts as having none.
+func (p *WalletPool) pendingCounts(ctx context.Context) map[common.Address]uint64 {
+	counts := map[common.Address]uint64{}
+	for _, wallet := range p.wallets {
+		n, err := p.pending(ctx, wallet)
+		if err != nil {
+			fmt.Println("Error getting pending transactions of", wallet.Hex(), ":", err)
+			continue
+		}
+		counts[wallet] = n
+	}
+	return counts
+}
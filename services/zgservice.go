@@ -2,58 +2,113 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math/big"
 	"os"
+	"sync"
+	"time"
+	"zgdrive/model"
 
-	"github.com/0glabs/0g-storage-client/common/blockchain"
 	"github.com/0glabs/0g-storage-client/core"
 	"github.com/0glabs/0g-storage-client/indexer"
 	"github.com/0glabs/0g-storage-client/node"
 	"github.com/0glabs/0g-storage-client/transfer"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/openweb3/web3go"
+	"github.com/openweb3/web3go/interfaces"
+	"github.com/openweb3/web3go/signers"
 )
 
 type ZgService struct {
-	evmRpc     string
-	privateKey string
-	flowAddr   string
-	indRpc     string
-	w3client   *web3go.Client
-	Indexer    *indexer.Client
+	evmRpc   string
+	flowAddr string
+	indRpc   string
+	// one client per paying wallet, each signing with only that wallet
+	wallets   []common.Address
+	w3clients map[common.Address]*web3go.Client
+	Indexer   *indexer.Client
+	// eth prices submissions and reads their receipts
+	eth *ethclient.Client
+
+	// streamed downloads fetch one segment at a time, so the selected
+	// nodes are kept for a while instead of asking the indexer every time
+	nodesMu sync.Mutex
+	nodes   []*node.ZgsClient
+	nodesAt time.Time
 }
 
 func NewZgService() (*ZgService, error) {
 
 	// get all from env
 	evmRpc := os.Getenv("EVM_RPC")
-	privateKey := os.Getenv("PRIVATE_KEY")
 	flowAddr := os.Getenv("FLOW_ADDR")
 	indRpc := os.Getenv("IND_RPC")
 
+	walletSigners, err := NewSignersFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
 	fmt.Println("evmRpc:", evmRpc)
-	fmt.Println("privateKey:", privateKey)
 	fmt.Println("flowAddr:", flowAddr)
 	fmt.Println("indRpc:", indRpc)
 
-	w3client := blockchain.MustNewWeb3(evmRpc, privateKey)
-	defer w3client.Close()
+	var wallets []common.Address
+	w3clients := map[common.Address]*web3go.Client{}
+	for _, signer := range walletSigners {
+		wallet := signer.Address()
+		if _, ok := w3clients[wallet]; ok {
+			return nil, fmt.Errorf("wallet %s is configured twice", wallet.Hex())
+		}
+		fmt.Println("wallet:", wallet.Hex())
+		w3client, err := web3go.NewClientWithOption(evmRpc, web3go.ClientOption{
+			SignerManager: signers.NewSignerManager([]interfaces.Signer{signer}),
+		})
+		if err != nil {
+			return nil, err
+		}
+		wallets = append(wallets, wallet)
+		w3clients[wallet] = w3client
+	}
 
 	standardIndexer, err := indexer.NewClient(indRpc)
 	if err != nil {
 		return nil, err
 	}
 
+	eth, err := ethclient.Dial(evmRpc)
+	if err != nil {
+		return nil, err
+	}
+
 	return &ZgService{
-		evmRpc:     evmRpc,
-		privateKey: privateKey,
-		flowAddr:   flowAddr,
-		indRpc:     indRpc,
-		w3client:   w3client,
-		Indexer:    standardIndexer,
+		evmRpc:    evmRpc,
+		flowAddr:  flowAddr,
+		indRpc:    indRpc,
+		wallets:   wallets,
+		w3clients: w3clients,
+		Indexer:   standardIndexer,
+		eth:       eth,
 	}, nil
 }
 
+// NewZgDownloader returns a ZgService that only downloads. It has no
+// wallets and no EVM client, so it can't submit or price anything.
+func NewZgDownloader() (*ZgService, error) {
+	indRpc := os.Getenv("IND_RPC")
+	fmt.Println("indRpc:", indRpc)
+
+	standardIndexer, err := indexer.NewClient(indRpc)
+	if err != nil {
+		return nil, err
+	}
+	return &ZgService{indRpc: indRpc, Indexer: standardIndexer}, nil
+}
+
 func FileHash(filePath string) (string, error) {
 	rootHash, err := core.MerkleRoot(filePath)
 	if err != nil {
@@ -72,8 +127,17 @@ func (z *ZgService) getNodes(ctx context.Context) ([]*node.ZgsClient, error) {
 	return nodes, nil
 }
 
-func (z *ZgService) UploadFile(ctx context.Context, file string) (string, error) {
-	fmt.Println("Uploading file:", file)
+func (z *ZgService) Wallets() []common.Address {
+	return z.wallets
+}
+
+func (z *ZgService) UploadFile(ctx context.Context, file string, wallet common.Address) (string, error) {
+	w3client, ok := z.w3clients[wallet]
+	if !ok {
+		return "", fmt.Errorf("unknown wallet %s", wallet.Hex())
+	}
+
+	fmt.Println("Uploading file:", file, "paid by", wallet.Hex())
 	nodes, err := z.getNodes(ctx)
 	if err != nil {
 		return "", err
@@ -81,11 +145,10 @@ func (z *ZgService) UploadFile(ctx context.Context, file string) (string, error)
 
 	fmt.Println("Nodes:", nodes)
 
-	uploader, err := transfer.NewUploader(ctx, z.w3client, nodes)
+	uploader, err := transfer.NewUploader(ctx, w3client, nodes)
 	if err != nil {
 		return "", err
 	}
-	fmt.Println("Uploader:", uploader)
 	tx, err := uploader.UploadFile(ctx, file)
 	if err != nil {
 		return "", err
@@ -121,6 +184,104 @@ func (z *ZgService) CheckFileStatus(ctx context.Context, rootHash string) (bool,
 	return false, nil
 }
 
+// ObjectInfo asks the storage nodes about an object, preferring a node
+// that has it finalized.
+func (z *ZgService) ObjectInfo(ctx context.Context, rootHash string) (model.ObjectInfo, error) {
+	nodes, err := z.getNodes(ctx)
+	if err != nil {
+		return model.ObjectInfo{}, err
+	}
+
+	var found *node.FileInfo
+	for _, v := range nodes {
+		info, err := v.GetFileInfo(ctx, common.HexToHash(rootHash))
+		if err != nil {
+			fmt.Println("Error getting file info:", err)
+			continue
+		}
+		if info != nil && (found == nil || info.Finalized) {
+			found = info
+		}
+		if found != nil && found.Finalized {
+			break
+		}
+	}
+	if found == nil {
+		return model.ObjectInfo{}, ErrObjectNotFound
+	}
+
+	return model.ObjectInfo{
+		RootHash:  found.Tx.DataMerkleRoot.Hex(),
+		Size:      int64(found.Tx.Size),
+		TxSeq:     found.Tx.Seq,
+		Finalized: found.Finalized,
+	}, nil
+}
+
+// SubmissionRoot reads the submission index from the Submit event the flow
+// contract emitted and asks the storage nodes which object it was.
+func (z *ZgService) SubmissionRoot(ctx context.Context, txId string) (string, error) {
+	receipt, err := z.eth.TransactionReceipt(ctx, common.HexToHash(txId))
+	if errors.Is(err, ethereum.NotFound) {
+		return "", ErrNotSubmission
+	}
+	if err != nil {
+		return "", fmt.Errorf("getting receipt of %s: %w", txId, err)
+	}
+
+	flow := common.HexToAddress(z.flowAddr)
+	submit := flowABI.Events["Submit"]
+	for _, l := range receipt.Logs {
+		if l.Address != flow || len(l.Topics) == 0 || l.Topics[0] != submit.ID {
+			continue
+		}
+		values, err := flowABI.Unpack("Submit", l.Data)
+		if err != nil {
+			return "", fmt.Errorf("decoding submission of %s: %w", txId, err)
+		}
+		seq := values[0].(*big.Int).Uint64()
+
+		nodes, err := z.getNodes(ctx)
+		if err != nil {
+			return "", err
+		}
+		for _, v := range nodes {
+			info, err := v.GetFileInfoByTxSeq(ctx, seq)
+			if err != nil {
+				fmt.Println("Error getting file info:", err)
+				continue
+			}
+			if info != nil {
+				return info.Tx.DataMerkleRoot.Hex(), nil
+			}
+		}
+		return "", ErrObjectNotFound
+	}
+	return "", ErrNotSubmission
+}
+
+func (z *ZgService) UploadedSegments(ctx context.Context, rootHash string) (uint64, error) {
+	nodes, err := z.getNodes(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	hash := common.HexToHash(rootHash)
+
+	var uploaded uint64
+	for _, v := range nodes {
+		info, err := v.GetFileInfo(ctx, hash)
+		if err != nil || info == nil {
+			continue
+		}
+		if info.UploadedSegNum > uploaded {
+			uploaded = info.UploadedSegNum
+		}
+	}
+
+	return uploaded, nil
+}
+
 func (z *ZgService) DownloadFile(ctx context.Context, file string, hash string) (bool, error) {
 	nodes, err := z.getNodes(ctx)
 	if err != nil {
@@ -139,3 +300,160 @@ func (z *ZgService) DownloadFile(ctx context.Context, file string, hash string)
 
 	return true, nil
 }
+
+// segmentNodes returns the storage nodes to fetch segments from, selecting
+// them again once a minute.
+func (z *ZgService) segmentNodes(ctx context.Context) ([]*node.ZgsClient, error) {
+	z.nodesMu.Lock()
+	defer z.nodesMu.Unlock()
+
+	if len(z.nodes) > 0 && time.Since(z.nodesAt) < 1*time.Minute {
+		return z.nodes, nil
+	}
+	nodes, err := z.getNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	z.nodes = nodes
+	z.nodesAt = time.Now()
+	return nodes, nil
+}
+
+func (z *ZgService) DownloadSegment(ctx context.Context, rootHash string, index uint64) ([]byte, error) {
+	nodes, err := z.segmentNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := common.HexToHash(rootHash)
+
+	lastErr := errors.New("no storage nodes available")
+	for _, v := range nodes {
+		segment, err := v.DownloadSegmentWithProof(ctx, hash, index)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if segment == nil {
+			lastErr = fmt.Errorf("segment %d not found on node %s", index, v.URL())
+			continue
+		}
+
+		// don't trust the node, check the segment belongs to the root hash
+		segmentRoot, numSegments := core.PaddedSegmentRoot(index, segment.Data, int64(segment.FileSize))
+		err = segment.Proof.ValidateHash(hash, segmentRoot, index, numSegments)
+		if err != nil {
+			lastErr = fmt.Errorf("invalid proof for segment %d from node %s: %w", index, v.URL(), err)
+			continue
+		}
+
+		// the last segment is padded to a whole number of chunks
+		data := segment.Data
+		remaining := int64(segment.FileSize) - int64(index)*core.DefaultSegmentSize
+		if remaining <= 0 {
+			return nil, fmt.Errorf("segment %d of file %s is out of range", index, rootHash)
+		}
+		if remaining < int64(len(data)) {
+			data = data[:remaining]
+		}
+		return data, nil
+	}
+
+	return nil, lastErr
+}
+
+// pricePerSector reads the storage price from the market contract the
+// flow contract charges through.
+func (z *ZgService) pricePerSector(ctx context.Context) (*big.Int, error) {
+	flow := common.HexToAddress(z.flowAddr)
+	var market common.Address
+	err := z.call(ctx, flowABI, flow, "market", &market)
+	if err != nil {
+		return nil, fmt.Errorf("reading market address: %w", err)
+	}
+	var price *big.Int
+	err = z.call(ctx, marketABI, market, "pricePerSector", &price)
+	if err != nil {
+		return nil, fmt.Errorf("reading price per sector: %w", err)
+	}
+	return price, nil
+}
+
+// call calls a view method without arguments and unpacks its only result
+// into out.
+func (z *ZgService) call(ctx context.Context, contract abi.ABI, to common.Address, method string, out any) error {
+	data, err := contract.Pack(method)
+	if err != nil {
+		return err
+	}
+	result, err := z.eth.CallContract(ctx, ethereum.CallMsg{To: &to, Data: data}, nil)
+	if err != nil {
+		return err
+	}
+	values, err := contract.Unpack(method, result)
+	if err != nil {
+		return err
+	}
+	return contract.Methods[method].Outputs.Copy(out, values)
+}
+
+func (z *ZgService) EstimateCost(ctx context.Context, size int64) (model.CostEstimate, error) {
+	price, err := z.pricePerSector(ctx)
+	if err != nil {
+		return model.CostEstimate{}, err
+	}
+	submission, sectors := estimateSubmission(size)
+	fee := new(big.Int).Mul(price, big.NewInt(sectors))
+
+	// the contract doesn't check node roots, so a submission of the same
+	// shape costs the same gas as the real one
+	data, err := flowABI.Pack("submit", submission)
+	if err != nil {
+		return model.CostEstimate{}, err
+	}
+	flow := common.HexToAddress(z.flowAddr)
+	gasLimit, err := z.eth.EstimateGas(ctx, ethereum.CallMsg{From: z.wallets[0], To: &flow, Value: fee, Data: data})
+	if err != nil {
+		return model.CostEstimate{}, fmt.Errorf("estimating gas: %w", err)
+	}
+	gasPrice, err := z.eth.SuggestGasPrice(ctx)
+	if err != nil {
+		return model.CostEstimate{}, fmt.Errorf("getting gas price: %w", err)
+	}
+
+	return newCostEstimate(size, sectors, price, gasLimit, gasPrice), nil
+}
+
+func (z *ZgService) TxCost(ctx context.Context, txId string) (model.TxCost, error) {
+	hash := common.HexToHash(txId)
+	receipt, err := z.eth.TransactionReceipt(ctx, hash)
+	if err != nil {
+		return model.TxCost{}, fmt.Errorf("getting receipt of %s: %w", txId, err)
+	}
+	tx, _, err := z.eth.TransactionByHash(ctx, hash)
+	if err != nil {
+		return model.TxCost{}, fmt.Errorf("getting transaction %s: %w", txId, err)
+	}
+
+	// the storage fee is the value sent to the flow contract
+	return newTxCost(txId, tx.Value(), receipt.GasUsed, receipt.EffectiveGasPrice), nil
+}
+
+func (z *ZgService) Balance(ctx context.Context, wallet common.Address) (*big.Int, error) {
+	return z.eth.BalanceAt(ctx, wallet, nil)
+}
+
+func (z *ZgService) PendingTransactions(ctx context.Context, wallet common.Address) (uint64, error) {
+	pending, err := z.eth.PendingNonceAt(ctx, wallet)
+	if err != nil {
+		return 0, err
+	}
+	mined, err := z.eth.NonceAt(ctx, wallet, nil)
+	if err != nil {
+		return 0, err
+	}
+	if pending < mined {
+		return 0, nil
+	}
+	return pending - mined, nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"zgdrive/model"
+	"zgdrive/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type createShareRequest struct {
+	// "view" or "download", download when empty
+	Permission string `json:"permission"`
+	Password   string `json:"password"`
+	// seconds until the link expires, never when 0
+	ExpiresIn    int64 `json:"expires_in"`
+	MaxDownloads int64 `json:"max_downloads"`
+}
+
+// registerShareRoutes adds managing share links to api and the public link
+// route to router.
+func registerShareRoutes(router gin.IRouter, api gin.IRouter, ctx context.Context, dbservice services.Store, w *workers) {
+	// @Summary Share a file
+	// @Description Create a public link to a file version, served at /s/{token}. The link can expire, need a password and allow a limited number of downloads.
+	// @Accept json
+	// @Produce json
+	// @Param fileId path int true "File ID"
+	// @Param share body createShareRequest true "Link restrictions"
+	// @Success 201 {object} model.Share
+	// @Failure 400 {object} gin.H "Invalid permission"
+	// @Failure 404 {object} gin.H "File not found"
+	// @Router /files/{fileId}/shares [post]
+	api.POST("/files/:fileId/shares", func(c *gin.Context) {
+		fileId, ok := paramInt(c, "fileId")
+		if !ok {
+			return
+		}
+		var req createShareRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if req.Permission == "" {
+			req.Permission = model.SharePermissionDownload
+		}
+		var expiresAt *time.Time
+		if req.ExpiresIn > 0 {
+			t := time.Now().Add(time.Duration(req.ExpiresIn) * time.Second)
+			expiresAt = &t
+		}
+
+		share, err := dbservice.CreateShare(ctx, currentUser(c).ID, fileId, req.Permission, req.Password, expiresAt, req.MaxDownloads)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("Location", "/s/"+share.Token)
+		c.JSON(http.StatusCreated, share)
+	})
+
+	// @Summary List share links
+	// @Description List the share links created by the signed in user, newest first
+	// @Produce json
+	// @Success 200 {array} model.Share
+	// @Router /shares [get]
+	api.GET("/shares", func(c *gin.Context) {
+		shares, err := dbservice.ListShares(ctx, currentUser(c).ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, shares)
+	})
+
+	// @Summary Revoke a share link
+	// @Param shareId path int true "Share ID"
+	// @Success 204
+	// @Failure 404 {object} gin.H "Share not found"
+	// @Router /shares/{shareId} [delete]
+	api.DELETE("/shares/:shareId", func(c *gin.Context) {
+		shareId, ok := paramInt(c, "shareId")
+		if !ok {
+			return
+		}
+
+		err := dbservice.DeleteShare(ctx, currentUser(c).ID, shareId)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	// @Summary Open a share link
+	// @Description Stream a shared file from the download cache or from 0G. View links open in the browser, download links are saved. Downloads are counted by the bytes served, a download for every file size's worth, so seeking and resuming use up only what they fetch. A single byte range is honoured, other ranges get the whole file. A password is sent in the X-Share-Password header or as the password form field of a POST, never in the URL.
+	// @Accept x-www-form-urlencoded
+	// @Produce octet-stream
+	// @Param token path string true "Share token"
+	// @Param X-Share-Password header string false "Link password"
+	// @Param password formData string false "Link password, with POST"
+	// @Param Range header string false "Byte range, e.g. bytes=1048576-"
+	// @Success 200 {file} file "Whole file"
+	// @Success 206 {file} file "Requested range"
+	// @Failure 401 {object} gin.H "Missing or wrong password"
+	// @Failure 404 {object} gin.H "Unknown link"
+	// @Failure 409 {object} gin.H "File is not stored on 0G yet"
+	// @Failure 410 {object} gin.H "Link expired or used up"
+	// @Router /s/{token} [get]
+	// @Router /s/{token} [post]
+	openShare := func(c *gin.Context) {
+		password := c.GetHeader("X-Share-Password")
+		if password == "" && c.Request.Method == http.MethodPost {
+			password = c.PostForm("password")
+		}
+		share, file, err := dbservice.OpenShare(ctx, c.Param("token"), password)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		content, closeContent, err := w.openContent(c.Request.Context(), file)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		defer closeContent()
+
+		if c.Request.Method != http.MethodHead {
+			err = dbservice.CountShareBytes(ctx, share.ID, servedBytes(c.Request, file.Size), file.Size)
+			if err != nil {
+				c.JSON(statusFor(err), gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		disposition := "attachment"
+		if share.Permission == model.SharePermissionView {
+			disposition = "inline"
+		}
+		c.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": file.Filename}))
+		c.Header("Cache-Control", "private, no-store")
+		http.ServeContent(c.Writer, c.Request, file.Filename, file.CreatedAt, content)
+	}
+	router.GET("/s/:token", openShare)
+	router.HEAD("/s/:token", openShare)
+	router.POST("/s/:token", openShare)
+}
+
+// servedBytes returns how many bytes of a file of size bytes the response
+// to req carries. Only a single satisfiable range is honoured; any other
+// Range header is dropped from req, as are conditional headers, so that the
+// whole file is served and counted.
+func servedBytes(req *http.Request, size int64) int64 {
+	if req.Header.Get("If-Range") != "" {
+		req.Header.Del("Range")
+	}
+	for _, name := range []string{"If-Range", "If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"} {
+		req.Header.Del(name)
+	}
+
+	n, ok := rangeLength(req.Header.Get("Range"), size)
+	if !ok {
+		req.Header.Del("Range")
+		return size
+	}
+	return n
+}
+
+// rangeLength returns the length of a Range header naming one range of a
+// file of size bytes, like bytes=0-99, bytes=100- or bytes=-100.
+func rangeLength(header string, size int64) (int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, false
+	}
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, false
+	}
+	first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+	if first == "" {
+		// the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, false
+		}
+		return min(n, size), true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, false
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, false
+		}
+		end = min(end, size-1)
+	}
+	return end - start + 1, true
+}
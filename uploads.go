@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"zgdrive/model"
+	"zgdrive/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ingestedUpload is a received file that was recorded and either queued
+// for submission or pointed at content already stored on 0G.
+type ingestedUpload struct {
+	File         model.File
+	JobId        int64
+	Deduplicated bool
+}
+
+// ingestUpload encrypts, hashes and records the file ownerId uploaded to
+// path, then stages it and queues an upload job. size is the size of the file as
+// received. Without encryption path is moved into the staging area when a
+// job is queued; otherwise it is left untouched, so the caller can retry
+// with it after an error. The caller removes whatever is left.
+func (w *workers) ingestUpload(ctx context.Context, ownerId int64, path, filename string, folderId, size int64) (ingestedUpload, error) {
+	// stage the ciphertext instead of the plaintext, so only the
+	// encrypted file is hashed and sent to 0G
+	content := path
+	var wrappedKey string
+	if w.encryptor != nil {
+		w.progress.Update(model.Progress{Kind: model.ProgressUpload, OwnerId: ownerId, Filename: filename, Phase: model.PhaseEncrypting, BytesTotal: size})
+		content = path + ".enc"
+		defer os.Remove(content)
+		var err error
+		wrappedKey, err = w.encryptor.EncryptFile(path, content)
+		if err != nil {
+			return ingestedUpload{}, err
+		}
+	}
+
+	w.progress.Update(model.Progress{Kind: model.ProgressUpload, OwnerId: ownerId, Filename: filename, Phase: model.PhaseHashing, BytesTotal: size})
+
+	hash, err := services.FileHash(content)
+	if err != nil {
+		return ingestedUpload{}, err
+	}
+
+	newFile := model.File{
+		OwnerId:    ownerId,
+		Filename:   filename,
+		FolderId:   folderId,
+		Hash:       hash,
+		Size:       size,
+		WrappedKey: wrappedKey,
+	}
+
+	// content that is already finalized on 0G is not submitted again,
+	// the new file points at the existing object. Encrypted content is
+	// sealed with a new data key every time, so its root hash never repeats
+	// and there is nothing to look up.
+	var existing model.File
+	err = sql.ErrNoRows
+	if w.encryptor == nil {
+		existing, err = w.db.FindUploadedObject(ctx, hash)
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return ingestedUpload{}, err
+	}
+	if err == nil {
+		newFile.TxId = existing.TxId
+		newFile.IsUploaded = true
+		uploadedFile, err := w.db.AddFile(ctx, newFile, w.defaultQuota)
+		if err != nil {
+			return ingestedUpload{}, err
+		}
+		w.progress.Update(model.Progress{
+			Kind:             model.ProgressUpload,
+			OwnerId:          ownerId,
+			FileId:           uploadedFile.ID,
+			Filename:         uploadedFile.Filename,
+			Phase:            model.PhaseDone,
+			BytesTotal:       uploadedFile.Size,
+			BytesTransferred: uploadedFile.Size,
+			SegmentsTotal:    services.NumSegments(uploadedFile.Size),
+			SegmentsDone:     services.NumSegments(uploadedFile.Size),
+		})
+		return ingestedUpload{File: uploadedFile, Deduplicated: true}, nil
+	}
+
+	uploadedFile, err := w.db.AddFile(ctx, newFile, w.defaultQuota)
+	if err != nil {
+		return ingestedUpload{}, err
+	}
+	err = os.Rename(content, w.layout.StagingPath(uploadedFile.ID))
+	if err != nil {
+		return ingestedUpload{}, err
+	}
+	job, err := w.db.AddUploadJob(ctx, uploadedFile.ID)
+	if err != nil {
+		return ingestedUpload{}, err
+	}
+	w.progress.Update(model.Progress{
+		Kind:          model.ProgressUpload,
+		OwnerId:       ownerId,
+		FileId:        uploadedFile.ID,
+		JobId:         job.ID,
+		Filename:      uploadedFile.Filename,
+		Phase:         model.PhaseQueued,
+		BytesTotal:    uploadedFile.Size,
+		SegmentsTotal: services.NumSegments(uploadedFile.Size),
+	})
+	select {
+	case w.uploadJobs <- struct{}{}:
+	default:
+	}
+
+	return ingestedUpload{File: uploadedFile, JobId: job.ID}, nil
+}
+
+func respondIngested(c *gin.Context, u ingestedUpload) {
+	if u.Deduplicated {
+		c.JSON(http.StatusOK, gin.H{"message": "File already stored on 0G. Root hash: " + u.File.Hash, "fileId": u.File.ID, "hash": u.File.Hash, "deduplicated": true})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "File queued for upload. Root hash: " + u.File.Hash, "jobId": u.JobId, "fileId": u.File.ID, "hash": u.File.Hash})
+}
+
+type createUploadSessionRequest struct {
+	Filename string `json:"filename" binding:"required"`
+	Size     int64  `json:"size"`
+	FolderId int64  `json:"folder_id"`
+}
+
+// registerUploadRoutes adds resumable uploads, modelled on the tus
+// protocol: a session is created with the final size, chunks are sent with
+// PATCH at the offset the server reports, and the session is finished once
+// every byte arrived.
+func registerUploadRoutes(router gin.IRouter, ctx context.Context, dbservice services.Store, w *workers) {
+	// chunks of one session are written one at a time
+	sessionLocks := services.NewKeyedMutex()
+
+	// @Summary Create a resumable upload
+	// @Description Start a resumable upload of a file of the given size. Its URL is returned in the Location header.
+	// @Accept json
+	// @Produce json
+	// @Param upload body createUploadSessionRequest true "File to upload"
+	// @Success 201 {object} model.UploadSession
+	// @Failure 400 {object} gin.H "Invalid request"
+	// @Failure 404 {object} gin.H "Folder not found"
+	// @Failure 413 {object} gin.H "Storage quota exceeded"
+	// @Failure 429 {object} gin.H "Daily upload limit reached"
+	// @Router /uploads [post]
+	router.POST("/uploads", func(c *gin.Context) {
+		var req createUploadSessionRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		filename, err := services.CleanFilename(req.Filename)
+		if err != nil || req.Size < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid filename or size"})
+			return
+		}
+		user := currentUser(c)
+		_, err = dbservice.GetFolder(ctx, user.ID, req.FolderId)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		err = dbservice.CheckQuota(ctx, user.ID, req.Size, w.defaultQuota)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		session, err := dbservice.CreateUploadSession(ctx, user.ID, filename, req.FolderId, req.Size)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		// an empty file has nothing to PATCH, so it exists right away
+		err = os.WriteFile(w.layout.UploadPath(session.ID), nil, 0600)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Header("Location", "/uploads/"+session.ID)
+		setUploadHeaders(c, session)
+		c.JSON(http.StatusCreated, session)
+	})
+
+	// @Summary Get a resumable upload
+	// @Description Get the state of a resumable upload. The offset to send the next chunk at is also returned in the Upload-Offset header, which is all a HEAD request returns.
+	// @Produce json
+	// @Param sessionId path string true "Upload session ID"
+	// @Success 200 {object} model.UploadSession
+	// @Failure 404 {object} gin.H "Upload not found"
+	// @Router /uploads/{sessionId} [get]
+	getSession := func(c *gin.Context) {
+		session, err := dbservice.GetUploadSession(ctx, currentUser(c).ID, c.Param("sessionId"))
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		setUploadHeaders(c, session)
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, session)
+	}
+	router.GET("/uploads/:sessionId", getSession)
+	router.HEAD("/uploads/:sessionId", getSession)
+
+	// @Summary Upload a chunk
+	// @Description Append the request body to a resumable upload. Upload-Offset must match the upload's current offset. When the connection drops, whatever arrived is kept and the upload can continue from the new offset.
+	// @Accept application/offset+octet-stream
+	// @Param sessionId path string true "Upload session ID"
+	// @Param Upload-Offset header int true "Offset the chunk starts at"
+	// @Success 204 "Chunk stored, the new offset is in the Upload-Offset header"
+	// @Failure 400 {object} gin.H "Missing or invalid Upload-Offset"
+	// @Failure 404 {object} gin.H "Upload not found"
+	// @Failure 409 {object} gin.H "Upload-Offset does not match the upload's offset"
+	// @Failure 413 {object} gin.H "Chunk goes past the size of the upload"
+	// @Router /uploads/{sessionId} [patch]
+	router.PATCH("/uploads/:sessionId", func(c *gin.Context) {
+		sessionId := c.Param("sessionId")
+		offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid Upload-Offset header"})
+			return
+		}
+
+		unlock := sessionLocks.Lock(sessionId)
+		defer unlock()
+
+		session, err := dbservice.GetUploadSession(ctx, currentUser(c).ID, sessionId)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		setUploadHeaders(c, session)
+		if offset != session.Offset {
+			c.JSON(http.StatusConflict, gin.H{"error": "Upload-Offset does not match the upload's offset", "offset": session.Offset})
+			return
+		}
+		remaining := session.Size - session.Offset
+		if c.Request.ContentLength > remaining {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "chunk goes past the size of the upload", "offset": session.Offset})
+			return
+		}
+
+		received, copyErr := appendChunk(w.layout.UploadPath(session.ID), session.Offset, io.LimitReader(c.Request.Body, remaining))
+		if received > 0 {
+			session.Offset += received
+			err = dbservice.SetUploadSessionOffset(ctx, session.ID, session.Offset)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		setUploadHeaders(c, session)
+		if copyErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": copyErr.Error(), "offset": session.Offset})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	// @Summary Finish a resumable upload
+	// @Description Hand a fully received upload to the upload pipeline, like a file sent to /upload
+	// @Produce json
+	// @Param sessionId path string true "Upload session ID"
+	// @Success 200 {object} gin.H "Content already stored on 0G, the new file points at it"
+	// @Success 202 {object} gin.H "File queued for upload, with its upload job id"
+	// @Failure 404 {object} gin.H "Upload not found"
+	// @Failure 409 {object} gin.H "Upload is missing bytes"
+	// @Failure 413 {object} gin.H "Storage quota exceeded"
+	// @Failure 429 {object} gin.H "Daily upload limit reached"
+	// @Router /uploads/{sessionId}/finish [post]
+	router.POST("/uploads/:sessionId/finish", func(c *gin.Context) {
+		user := currentUser(c)
+		sessionId := c.Param("sessionId")
+		unlock := sessionLocks.Lock(sessionId)
+		defer unlock()
+
+		session, err := dbservice.GetUploadSession(ctx, user.ID, sessionId)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		if session.Offset != session.Size {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("upload is incomplete, %d of %d bytes received", session.Offset, session.Size), "offset": session.Offset})
+			return
+		}
+
+		// the folder may have been deleted and other uploads may have used
+		// up the quota while the upload was running
+		_, err = dbservice.GetFolder(ctx, user.ID, session.FolderId)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		err = dbservice.CheckQuota(ctx, user.ID, session.Size, w.defaultQuota)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		tempPath, err := w.layout.TempPath()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer os.Remove(tempPath)
+		err = os.Rename(w.layout.UploadPath(session.ID), tempPath)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		uploaded, err := w.ingestUpload(ctx, user.ID, tempPath, session.Filename, session.FolderId, session.Size)
+		if err != nil {
+			// keep the session and its bytes, so finishing can be retried
+			restoreErr := os.Rename(tempPath, w.layout.UploadPath(session.ID))
+			if restoreErr != nil {
+				fmt.Println("Error restoring upload:", restoreErr)
+			}
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		// the session is gone once the file is recorded, a stale one is
+		// swept later
+		err = dbservice.DeleteUploadSession(ctx, session.ID)
+		if err != nil {
+			fmt.Println("Error deleting upload session:", err)
+		}
+		respondIngested(c, uploaded)
+	})
+
+	// @Summary Cancel a resumable upload
+	// @Description Drop a resumable upload and the bytes received so far
+	// @Param sessionId path string true "Upload session ID"
+	// @Success 204
+	// @Failure 404 {object} gin.H "Upload not found"
+	// @Router /uploads/{sessionId} [delete]
+	router.DELETE("/uploads/:sessionId", func(c *gin.Context) {
+		sessionId := c.Param("sessionId")
+		unlock := sessionLocks.Lock(sessionId)
+		defer unlock()
+
+		session, err := dbservice.GetUploadSession(ctx, currentUser(c).ID, sessionId)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		err = dbservice.DeleteUploadSession(ctx, session.ID)
+		if err != nil {
+			c.JSON(statusFor(err), gin.H{"error": err.Error()})
+			return
+		}
+		err = os.Remove(w.layout.UploadPath(session.ID))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Println("Error deleting upload:", err)
+		}
+		c.Status(http.StatusNoContent)
+	})
+}
+
+func setUploadHeaders(c *gin.Context, session model.UploadSession) {
+	c.Header("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(session.Size, 10))
+}
+
+// appendChunk writes r to path starting at offset. Anything past offset is
+// dropped first, so bytes written after the offset was last recorded (e.g.
+// before a crash) are overwritten. It returns how many bytes were written,
+// which may be more than zero when an error is returned.
+func appendChunk(path string, offset int64, r io.Reader) (int64, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+
+	err = f.Truncate(offset)
+	if err == nil {
+		_, err = f.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+
+	n, err := io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
@@ -0,0 +1,661 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+	"zgdrive/model"
+	"zgdrive/services"
+
+	"github.com/0glabs/0g-storage-client/core"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// workers holds the dependencies of the background workers. Each method
+// handles one unit of work and is run in a loop by services.Supervisor, so
+// returning an error backs the worker off instead of stopping it.
+type workers struct {
+	db                services.Store
+	storage           services.StorageBackend
+	uploadJobs        chan struct{}
+	downloads         chan downloadRequest
+	downloadLocks     *services.KeyedMutex
+	layout            *services.Layout
+	progress          *services.ProgressTracker
+	encryptor         *services.Encryptor
+	maxUploadAttempts int
+	defaultQuota      model.QuotaLimits
+	walletQuota       model.WalletLimits
+	balances          *services.WalletMonitor
+	wallets           *services.WalletPool
+	// backupLog is the file every metadata backup's root hash is appended to
+	backupLog string
+	// instance names this server among the instances sharing the database
+	instance string
+}
+
+// downloadRequest is a queued download; ID is the downloaded_files row.
+type downloadRequest struct {
+	ID   int64
+	File model.File
+}
+
+var errDownloadQueueFull = errors.New("download queue is full, try again later")
+
+// queueDownload records a download of file and hands it to the download
+// workers. It returns the downloaded_files row id.
+func (w *workers) queueDownload(ctx context.Context, file model.File) (int64, error) {
+	jobId, err := w.db.NewDownloadedFile(ctx, file, w.instance)
+	if err != nil {
+		return 0, err
+	}
+
+	select {
+	case w.downloads <- downloadRequest{ID: jobId, File: file}:
+		w.progress.Update(model.Progress{
+			Kind:          model.ProgressDownload,
+			OwnerId:       file.OwnerId,
+			FileId:        file.ID,
+			JobId:         jobId,
+			Filename:      file.Filename,
+			Phase:         model.PhaseQueued,
+			BytesTotal:    file.Size,
+			SegmentsTotal: services.NumSegments(file.Size),
+		})
+		return jobId, nil
+	default:
+		w.db.FailDownloadedFile(ctx, jobId)
+		return 0, errDownloadQueueFull
+	}
+}
+
+// uploadNext submits the oldest queued upload job to storage.
+func (w *workers) uploadNext(ctx context.Context) error {
+	// jobs stay queued while no wallet can pay, checkBalance wakes the
+	// workers once one is topped up
+	if !w.balances.Sufficient() {
+		select {
+		case <-w.uploadJobs:
+		case <-time.After(10 * time.Second):
+		case <-ctx.Done():
+		}
+		return nil
+	}
+
+	job, err := w.db.ClaimNextUploadJob(ctx, w.instance)
+	if errors.Is(err, sql.ErrNoRows) {
+		// queue is empty, wait for a new upload or re-check periodically
+		select {
+		case <-w.uploadJobs:
+		case <-time.After(10 * time.Second):
+		case <-ctx.Done():
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("claiming upload job: %w", err)
+	}
+
+	newFile, err := w.db.GetFileById(ctx, job.FileId)
+	if err != nil {
+		w.failUploadJob(ctx, job, err)
+		return fmt.Errorf("getting file %d for upload job %d: %w", job.FileId, job.ID, err)
+	}
+	fmt.Printf("Uploading file %d (job %d)\n", newFile.ID, job.ID)
+	progress := model.Progress{
+		Kind:          model.ProgressUpload,
+		OwnerId:       newFile.OwnerId,
+		FileId:        newFile.ID,
+		JobId:         job.ID,
+		Filename:      newFile.Filename,
+		Phase:         model.PhaseTxSubmission,
+		BytesTotal:    newFile.Size,
+		SegmentsTotal: services.NumSegments(newFile.Size),
+	}
+	w.progress.Update(progress)
+
+	// the same content may have been finalized while this job was queued,
+	// reuse that object instead of paying for a second submission
+	existing, err := w.db.FindUploadedObject(ctx, newFile.Hash)
+	if err == nil {
+		fmt.Println("Root hash already stored on 0G, skipping submission:", newFile.Hash)
+		err = w.db.SetUploadJobSubmitted(ctx, job, existing.TxId, "")
+		if err != nil {
+			return fmt.Errorf("updating upload job %d: %w", job.ID, err)
+		}
+		progress.Phase = model.PhaseFinalityWait
+		w.progress.Update(progress)
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		w.failUploadJob(ctx, job, err)
+		return fmt.Errorf("looking up root hash %s: %w", newFile.Hash, err)
+	}
+
+	// the content only exists in the staging area, without it there is
+	// nothing to submit
+	staged := w.layout.StagingPath(newFile.ID)
+	_, err = os.Stat(staged)
+	if errors.Is(err, os.ErrNotExist) {
+		err = fmt.Errorf("staged content is gone, upload the file again: %w", err)
+	}
+	if err != nil {
+		w.failUploadJob(ctx, job, err)
+		return fmt.Errorf("uploading file %s: %w", newFile.Filename, err)
+	}
+
+	wallet, err := w.acquireWallet(ctx, newFile)
+	if errors.Is(err, services.ErrWalletFileTooLarge) {
+		w.failUploadJob(ctx, job, err)
+		return fmt.Errorf("picking a wallet for %s: %w", newFile.Filename, err)
+	}
+	if err != nil {
+		w.requeueUploadJob(ctx, job, err)
+		return fmt.Errorf("picking a wallet for %s: %w", newFile.Filename, err)
+	}
+
+	// the 0G uploader has no progress callback, so ask the storage nodes how
+	// many segments arrived while the upload is running
+	stopWatching := w.watchUploadedSegments(ctx, progress, newFile.Hash)
+	tx, err := w.storage.UploadFile(ctx, staged, wallet)
+	stopWatching()
+	w.wallets.Release(wallet)
+	if services.IsInsufficientFunds(err) {
+		w.balances.MarkInsufficient(wallet, err)
+		w.requeueUploadJob(ctx, job, err)
+		return fmt.Errorf("uploading file %s: %w", newFile.Filename, err)
+	}
+	if err != nil {
+		w.failUploadJob(ctx, job, err)
+		return fmt.Errorf("uploading file %s: %w", newFile.Filename, err)
+	}
+	fmt.Println("Transaction hash:", tx)
+
+	err = w.db.SetUploadJobSubmitted(ctx, job, tx, wallet.Hex())
+	if err != nil {
+		return fmt.Errorf("updating upload job %d: %w", job.ID, err)
+	}
+
+	if latest, ok := w.progress.Get(model.ProgressUpload, newFile.ID); ok {
+		progress = latest
+	}
+	progress.Phase = model.PhaseFinalityWait
+	w.progress.Update(progress)
+	return nil
+}
+
+// acquireWallet picks a wallet to pay for file and counts the submission
+// against the wallet's daily limits. Wallets that reached them are passed
+// over for the others; when every wallet did, ErrWalletLimit is returned,
+// or ErrWalletFileTooLarge when the file is too large for all of them.
+func (w *workers) acquireWallet(ctx context.Context, file model.File) (common.Address, error) {
+	refused := map[common.Address]bool{}
+	usable := func(wallet common.Address) bool {
+		return !refused[wallet] && w.balances.Usable(wallet)
+	}
+
+	var limitErr error
+	for {
+		wallet, err := w.wallets.Acquire(ctx, file.OwnerId, usable)
+		if errors.Is(err, services.ErrNoWallet) && limitErr != nil {
+			return common.Address{}, limitErr
+		}
+		if err != nil {
+			return common.Address{}, err
+		}
+
+		err = w.db.ReserveWalletQuota(ctx, wallet.Hex(), submittedSize(file), w.walletQuota)
+		if err == nil {
+			return wallet, nil
+		}
+		w.wallets.Release(wallet)
+		if !errors.Is(err, services.ErrWalletLimit) && !errors.Is(err, services.ErrWalletFileTooLarge) {
+			return common.Address{}, err
+		}
+		refused[wallet] = true
+		// a wallet with room tomorrow beats one the file never fits
+		if limitErr == nil || errors.Is(limitErr, services.ErrWalletFileTooLarge) {
+			limitErr = err
+		}
+	}
+}
+
+func (w *workers) failUploadJob(ctx context.Context, job model.UploadJob, jobErr error) {
+	maxAttempts := w.maxUploadAttempts
+	// a staged file that is gone or that no wallet may ever submit won't
+	// do better on a retry
+	if errors.Is(jobErr, os.ErrNotExist) || errors.Is(jobErr, services.ErrWalletFileTooLarge) {
+		maxAttempts = 0
+	}
+	state, err := w.db.FailUploadJob(ctx, job, maxAttempts, jobErr)
+	if err != nil {
+		fmt.Println("Error marking upload job as failed:", err)
+	}
+
+	phase := model.PhaseQueued
+	if state == model.UploadJobFailed {
+		phase = model.PhaseFailed
+	}
+	progress, ok := w.progress.Get(model.ProgressUpload, job.FileId)
+	if !ok {
+		progress = model.Progress{Kind: model.ProgressUpload, FileId: job.FileId, JobId: job.ID}
+	}
+	progress.Phase = phase
+	progress.Error = jobErr.Error()
+	w.progress.Update(progress)
+}
+
+// requeueUploadJob queues a job again without counting the attempt.
+func (w *workers) requeueUploadJob(ctx context.Context, job model.UploadJob, jobErr error) {
+	err := w.db.RequeueUploadJob(ctx, job, jobErr)
+	if err != nil {
+		fmt.Println("Error requeueing upload job:", err)
+	}
+
+	progress, ok := w.progress.Get(model.ProgressUpload, job.FileId)
+	if !ok {
+		progress = model.Progress{Kind: model.ProgressUpload, FileId: job.FileId, JobId: job.ID}
+	}
+	progress.Phase = model.PhaseQueued
+	progress.Error = jobErr.Error()
+	w.progress.Update(progress)
+}
+
+// checkBalance reads the balance of the paying wallets and uses a wallet
+// again once it is back above the minimum.
+func (w *workers) checkBalance(ctx context.Context) error {
+	var resumed bool
+	var errs []error
+	for _, wallet := range w.storage.Wallets() {
+		balance, err := w.storage.Balance(ctx, wallet)
+		if w.balances.Update(wallet, balance, err) {
+			fmt.Printf("Wallet %s balance %s, using it again\n", wallet.Hex(), balance)
+			resumed = true
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("checking balance of %s: %w", wallet.Hex(), err))
+		}
+	}
+
+	for _, status := range w.balances.Statuses() {
+		if !status.Sufficient {
+			fmt.Printf("Wallet %s can't pay (balance %s, minimum %s), not using it\n", status.Address, status.Balance, status.MinBalance)
+		}
+	}
+	if !w.balances.Sufficient() {
+		fmt.Println("No wallet can pay, uploads are paused")
+	}
+	if resumed {
+		for i := 0; i < cap(w.uploadJobs); i++ {
+			select {
+			case w.uploadJobs <- struct{}{}:
+			default:
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// watchUploadedSegments polls the storage nodes for the number of uploaded
+// segments until the returned stop function is called.
+func (w *workers) watchUploadedSegments(ctx context.Context, progress model.Progress, rootHash string) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(2 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			segments, err := w.storage.UploadedSegments(ctx, rootHash)
+			if err != nil || segments == progress.SegmentsDone {
+				continue
+			}
+			progress.Phase = model.PhaseSegmentUpload
+			progress.SegmentsDone = segments
+			progress.BytesTransferred = services.SegmentBytes(segments, progress.BytesTotal)
+			w.progress.Update(progress)
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// downloadNext fetches the next requested file from storage into the cache.
+func (w *workers) downloadNext(ctx context.Context) error {
+	var req downloadRequest
+	select {
+	case req = <-w.downloads:
+	case <-ctx.Done():
+		return nil
+	}
+	downloadedFile := req.File
+	fmt.Println("Downloading file:", downloadedFile.ID)
+
+	// downloads of the same content end up in the same cache entry
+	unlock := w.downloadLocks.Lock(downloadedFile.Hash)
+	defer unlock()
+
+	progress := model.Progress{
+		Kind:          model.ProgressDownload,
+		OwnerId:       downloadedFile.OwnerId,
+		FileId:        downloadedFile.ID,
+		JobId:         req.ID,
+		Filename:      downloadedFile.Filename,
+		Phase:         model.PhaseDownloading,
+		BytesTotal:    downloadedFile.Size,
+		SegmentsTotal: services.NumSegments(downloadedFile.Size),
+	}
+	w.progress.Update(progress)
+
+	// download file from zgdrive
+	filePath, err := w.layout.TempPath()
+	if err != nil {
+		w.failDownload(ctx, req, progress, err)
+		return err
+	}
+	defer os.Remove(filePath)
+	stopWatching := w.watchDownloadedBytes(ctx, progress, filePath)
+	isDone, err := w.storage.DownloadFile(ctx, filePath, downloadedFile.Hash)
+	stopWatching()
+	if err != nil {
+		w.failDownload(ctx, req, progress, err)
+		return fmt.Errorf("downloading file %s: %w", downloadedFile.Filename, err)
+	}
+	if !isDone {
+		err = errors.New("storage did not return the whole file")
+		w.failDownload(ctx, req, progress, err)
+		return fmt.Errorf("downloading file %s: %w", downloadedFile.Filename, err)
+	}
+
+	// move file to the download cache, decrypting it on the way
+	err = w.finishDownload(downloadedFile, filePath, w.layout.CachePath(downloadedFile.Hash))
+	if err != nil {
+		w.failDownload(ctx, req, progress, err)
+		return fmt.Errorf("moving file %s: %w", downloadedFile.Filename, err)
+	}
+	err = w.db.FinishDownloadedFile(ctx, req.ID)
+	if err != nil {
+		w.failDownload(ctx, req, progress, err)
+		return fmt.Errorf("marking download %d as done: %w", req.ID, err)
+	}
+
+	progress.Phase = model.PhaseDone
+	progress.BytesTransferred = progress.BytesTotal
+	progress.SegmentsDone = progress.SegmentsTotal
+	w.progress.Update(progress)
+	return nil
+}
+
+func (w *workers) finishDownload(file model.File, filePath, downloadedPath string) error {
+	if !file.Encrypted {
+		return os.Rename(filePath, downloadedPath)
+	}
+
+	if w.encryptor == nil {
+		return errors.New("file is encrypted but ENCRYPTION_MASTER_KEY is not set")
+	}
+	plainPath := filePath + ".plain"
+	defer os.Remove(plainPath)
+	err := w.encryptor.DecryptFile(filePath, plainPath, file.WrappedKey)
+	if err != nil {
+		return err
+	}
+	return os.Rename(plainPath, downloadedPath)
+}
+
+var errNotStored = errors.New("file is not stored on 0G yet")
+
+// openContent returns the plaintext of a file for streaming and the
+// function that closes it. A copy in the download cache is used when there
+// is one, otherwise segments are fetched from storage as they are read.
+func (w *workers) openContent(ctx context.Context, file model.File) (io.ReadSeeker, func(), error) {
+	cached, err := os.Open(w.layout.CachePath(file.Hash))
+	if err == nil {
+		return cached, func() { cached.Close() }, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, err
+	}
+	if !file.IsUploaded {
+		return nil, nil, errNotStored
+	}
+
+	if !file.Encrypted {
+		segments := services.NewSegmentReader(ctx, w.storage, file.Hash, file.Size)
+		return io.NewSectionReader(segments, 0, segments.Size()), func() {}, nil
+	}
+
+	if w.encryptor == nil {
+		return nil, nil, errors.New("file is encrypted but ENCRYPTION_MASTER_KEY is not set")
+	}
+	segments := services.NewSegmentReader(ctx, w.storage, file.Hash, services.EncryptedSize(file.Size))
+	plain, err := w.encryptor.NewDecryptReader(segments, segments.Size(), file.WrappedKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return io.NewSectionReader(plain, 0, plain.Size()), func() {}, nil
+}
+
+func (w *workers) failDownload(ctx context.Context, req downloadRequest, progress model.Progress, downloadErr error) {
+	err := w.db.FailDownloadedFile(ctx, req.ID)
+	if err != nil {
+		fmt.Println("Error marking download as failed:", err)
+	}
+
+	progress.Phase = model.PhaseFailed
+	progress.Error = downloadErr.Error()
+	w.progress.Update(progress)
+}
+
+// watchDownloadedBytes reports how much of path has been written until the
+// returned stop function is called.
+func (w *workers) watchDownloadedBytes(ctx context.Context, progress model.Progress, path string) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(1 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			written := stagedSize(path)
+			if written == progress.BytesTransferred {
+				continue
+			}
+			progress.BytesTransferred = written
+			progress.SegmentsDone = uint64(written / core.DefaultSegmentSize)
+			w.progress.Update(progress)
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// stagedSize returns the size of a file being downloaded to path. The 0G
+// downloader writes to a temporary file next to the target and renames it
+// when done, so those are looked at as well.
+func stagedSize(path string) int64 {
+	var size int64
+	candidates, _ := filepath.Glob(path + ".*")
+	for _, candidate := range append(candidates, path) {
+		info, err := os.Stat(candidate)
+		if err == nil && info.Size() > size {
+			size = info.Size()
+		}
+	}
+	return size
+}
+
+// sweepExpired removes downloaded files that are older than an hour.
+func (w *workers) sweepExpired(ctx context.Context) error {
+	files, err := w.db.GetExpiredDownloadedFiles(ctx, 1*time.Hour)
+	if err != nil {
+		return fmt.Errorf("getting expired downloaded files: %w", err)
+	}
+
+	var lastErr error
+	for _, file := range files {
+		fmt.Println("Expired download:", file.ID)
+		err = w.expireDownload(ctx, file)
+		if err != nil {
+			fmt.Println("Error deleting file:", err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// expireDownload drops an expired download and deletes its cache entry,
+// unless a download that hasn't expired has the same content.
+func (w *workers) expireDownload(ctx context.Context, file model.File) error {
+	// hold off downloads of the same content until the entry is gone
+	unlock := w.downloadLocks.Lock(file.Hash)
+	defer unlock()
+
+	shared, err := w.db.IsCacheShared(ctx, file.ID, file.Hash)
+	if err != nil {
+		return err
+	}
+	if !shared {
+		err = os.Remove(w.layout.CachePath(file.Hash))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return w.db.RemoveDownloadedFile(ctx, file.ID)
+}
+
+// sweepUploadSessions removes resumable uploads that received nothing for a
+// day.
+func (w *workers) sweepUploadSessions(ctx context.Context) error {
+	sessions, err := w.db.GetStaleUploadSessions(ctx, 24*time.Hour)
+	if err != nil {
+		return fmt.Errorf("getting stale upload sessions: %w", err)
+	}
+
+	var lastErr error
+	for _, session := range sessions {
+		fmt.Println("Stale upload session:", session.ID)
+		err = os.Remove(w.layout.UploadPath(session.ID))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Println("Error deleting upload:", err)
+			lastErr = err
+			continue
+		}
+		err = w.db.DeleteUploadSession(ctx, session.ID)
+		if err != nil {
+			fmt.Println("Error deleting upload session:", err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// pollFinality marks submitted files as uploaded once 0G reports them.
+func (w *workers) pollFinality(ctx context.Context) error {
+	files, err := w.db.GetUnuploadedFiles(ctx)
+	if err != nil {
+		return fmt.Errorf("getting unuploaded files: %w", err)
+	}
+
+	var lastErr error
+	for _, file := range files {
+		isDone, err := w.storage.CheckFileStatus(ctx, file.Hash)
+		if err != nil {
+			fmt.Println("Error checking file status:", err)
+			lastErr = err
+			continue
+		}
+
+		progress, ok := w.progress.Get(model.ProgressUpload, file.ID)
+		if !ok {
+			progress = model.Progress{
+				Kind:          model.ProgressUpload,
+				OwnerId:       file.OwnerId,
+				FileId:        file.ID,
+				Filename:      file.Filename,
+				BytesTotal:    file.Size,
+				SegmentsTotal: services.NumSegments(file.Size),
+			}
+		}
+		if !isDone {
+			progress.Phase = model.PhaseFinalityWait
+			segments, err := w.storage.UploadedSegments(ctx, file.Hash)
+			if err == nil && segments > progress.SegmentsDone {
+				progress.SegmentsDone = segments
+				progress.BytesTransferred = services.SegmentBytes(segments, file.Size)
+			}
+			w.progress.Update(progress)
+		} else {
+			// the jobs go first: until the file is marked uploaded it is
+			// polled again, so a failure of either is retried
+			err = w.db.FinalizeUploadJobs(ctx, file.ID)
+			if err == nil {
+				err = w.db.SetUploaded(ctx, file.ID)
+			}
+			if err != nil {
+				fmt.Println("Error marking file as uploaded:", err)
+				lastErr = err
+				continue
+			}
+			fmt.Println("Finalized file:", file.ID)
+			// the submission is mined by now, record what it cost
+			err = w.recordCost(ctx, file)
+			if err != nil {
+				fmt.Println("Error recording cost:", err)
+			}
+			progress.Phase = model.PhaseDone
+			progress.SegmentsDone = progress.SegmentsTotal
+			progress.BytesTransferred = progress.BytesTotal
+			w.progress.Update(progress)
+			err = os.Remove(w.layout.StagingPath(file.ID))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				fmt.Println("Error deleting file:", err)
+			}
+		}
+	}
+	return lastErr
+}
+
+// recordCost stores what submitting file cost, read from its transaction.
+func (w *workers) recordCost(ctx context.Context, file model.File) error {
+	cost, err := w.storage.TxCost(ctx, file.TxId)
+	if err != nil {
+		return err
+	}
+	return w.db.AddFileCost(ctx, file, cost)
+}
+
+// submittedSize is how many bytes of file are sent to 0G.
+func submittedSize(file model.File) int64 {
+	if file.Encrypted {
+		return services.EncryptedSize(file.Size)
+	}
+	return file.Size
+}
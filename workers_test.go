@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"zgdrive/model"
+	"zgdrive/services"
+)
+
+// newTestWorkers returns workers backed by FakeStorage with no finality
+// delay and a new SQLite database, all kept in a temporary directory.
+func newTestWorkers(t *testing.T, encryptor *services.Encryptor) *workers {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("DATA_DIR", filepath.Join(dir, "data"))
+	t.Setenv("FAKE_STORAGE_DIR", filepath.Join(dir, "fakestorage"))
+	t.Setenv("FAKE_FINALITY_DELAY", "0s")
+
+	storage, err := services.NewFakeStorage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout, err := services.NewLayoutFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := services.OpenDBService(filepath.Join(dir, "files.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	err = db.MigrateTo(context.Background(), services.LatestSchemaVersion())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wallets, err := services.NewWalletPool(storage.Wallets(), "", storage.PendingTransactions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &workers{
+		db:                db,
+		storage:           storage,
+		uploadJobs:        make(chan struct{}, 1),
+		downloads:         make(chan downloadRequest, 1),
+		downloadLocks:     services.NewKeyedMutex(),
+		layout:            layout,
+		progress:          services.NewProgressTracker(),
+		encryptor:         encryptor,
+		maxUploadAttempts: 5,
+		balances:          services.NewWalletMonitor(big.NewInt(0), storage.Wallets()),
+		wallets:           wallets,
+		instance:          "test",
+	}
+}
+
+// TestUploadAndDownload takes a file from the upload handler's ingest
+// through submission and finality on FakeStorage, and back out through
+// the download worker and streaming.
+func TestUploadAndDownload(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		encryptor func(t *testing.T) *services.Encryptor
+	}{
+		{"plain", func(t *testing.T) *services.Encryptor { return nil }},
+		{"encrypted", func(t *testing.T) *services.Encryptor {
+			t.Setenv("ENCRYPTION_MASTER_KEY", "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+			encryptor, err := services.NewEncryptorFromEnv()
+			if err != nil {
+				t.Fatal(err)
+			}
+			return encryptor
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			testUploadAndDownload(t, newTestWorkers(t, test.encryptor(t)))
+		})
+	}
+}
+
+func testUploadAndDownload(t *testing.T, w *workers) {
+	ctx := context.Background()
+	user, err := w.db.CreateUser(ctx, "alice", "correct-horse-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// more than one segment, with a partial one at the end
+	content := bytes.Repeat([]byte("zgdrive end to end\n"), 30_000)
+	path, err := w.layout.TempPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, content, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ingested, err := w.ingestUpload(ctx, user.ID, path, "notes.txt", 0, int64(len(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := ingested.File
+	if file.Encrypted != (w.encryptor != nil) {
+		t.Fatalf("got encrypted %t with encryptor %v", file.Encrypted, w.encryptor)
+	}
+
+	// the root hash recorded at ingest is the one of the staged content,
+	// which is what gets submitted
+	staged := w.layout.StagingPath(file.ID)
+	root, err := services.FileHash(staged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root != file.Hash {
+		t.Fatalf("staged content has root %s, the file was recorded with %s", root, file.Hash)
+	}
+
+	err = w.uploadNext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err = w.db.GetFileById(ctx, file.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.TxId == "" {
+		t.Fatal("the upload job submitted no transaction")
+	}
+	submitted, err := w.storage.SubmissionRoot(ctx, file.TxId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if submitted != file.Hash {
+		t.Fatalf("storage computed root %s, FileHash %s", submitted, file.Hash)
+	}
+
+	err = w.pollFinality(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err = w.db.GetFileById(ctx, file.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !file.IsUploaded {
+		t.Fatal("the file wasn't finalized")
+	}
+	jobs, err := w.db.ListUploadJobs(ctx, user.ID)
+	if err != nil || len(jobs) != 1 || jobs[0].State != model.UploadJobFinalized {
+		t.Fatalf("got jobs %+v, %v", jobs, err)
+	}
+	_, err = os.Stat(staged)
+	if !os.IsNotExist(err) {
+		t.Errorf("the staged content is left after finality: %v", err)
+	}
+	_, err = w.db.GetFileCost(ctx, user.ID, file.ID)
+	if err != nil {
+		t.Errorf("no cost recorded: %v", err)
+	}
+
+	// streamed from storage segment by segment, nothing is cached yet
+	stream, closeStream, err := w.openContent(ctx, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamed, err := io.ReadAll(stream)
+	closeStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(streamed, content) {
+		t.Errorf("streamed %d bytes that differ from the %d uploaded", len(streamed), len(content))
+	}
+
+	_, err = w.queueDownload(ctx, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.downloadNext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done, err := w.db.CheckDownloadStatus(ctx, user.ID, file.Hash)
+	if err != nil || !done {
+		t.Fatalf("download done %t, %v", done, err)
+	}
+	cached, err := os.ReadFile(w.layout.CachePath(file.Hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cached, content) {
+		t.Errorf("cached %d bytes that differ from the %d uploaded", len(cached), len(content))
+	}
+}